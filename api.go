@@ -0,0 +1,127 @@
+package main
+
+import (
+  "log"
+  "math"
+  "strconv"
+  "net/http"
+  "encoding/json"
+)
+
+// JSON versions of /search and /filter for notebooks and dashboards,
+// taking the same query string parameters
+
+const maxAPIPageSz = 100 // largest size parameter for /api/v1/hits
+
+type APIError struct {
+  Error struct {
+    Status  int    `json:"status"`
+    Message string `json:"message"`
+  } `json:"error"`
+}
+
+// series are sections, or the search terms when comparing several
+type APIHistogram struct {
+  SearchTerm string                       `json:"search_term"`
+  Terms      []string                     `json:"search_terms"`
+  StockIndex string                       `json:"stock_index"`
+  Series     []string                     `json:"series"`
+  Counts     map[string](map[string]int)  `json:"counts"` // series, year, filings
+  // series, year, percent of filings with the section, with normalize
+  Shares     map[string](map[string]float64) `json:"shares,omitempty"`
+}
+
+type APIHits struct {
+  SearchTerm string `json:"search_term"`
+  StockIndex string `json:"stock_index"`
+  Section    string `json:"section"`
+  Total      int    `json:"total"`
+  Page       int    `json:"page"`
+  Pages      int    `json:"pages"`
+  Size       int    `json:"size"`
+  Next       string `json:"next,omitempty"` // cursor parameter for the next page
+  Hits       []Hit  `json:"hits"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  if err := json.NewEncoder(w).Encode(v); err != nil {
+    log.Printf("error writing JSON response: %s\n", err.Error())
+  }
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+  var e APIError
+  e.Error.Status = status
+  e.Error.Message = message
+  writeJSON(w, status, e)
+}
+
+// like processParameters, with errors as JSON
+func processAPIParameters(fn func (http.ResponseWriter, *http.Request, *Parameters)) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    p, err := parseParameters(r)
+    if err != nil {
+      writeJSONError(w, http.StatusBadRequest, err.Error())
+      return
+    }
+    logRequest(r, p)
+    fn(w, r, p)
+  }
+}
+
+func apiHistogramHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
+  series, counts, shares, err := histogram(p)
+  if err != nil {
+    writeJSONError(w, http.StatusInternalServerError, "histogram search error")
+    log.Printf("in apiHistogramHandler with search term '%s', histogram search error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+
+  writeJSON(w, http.StatusOK, APIHistogram{
+    SearchTerm: p.searchTerm,
+    Terms:      p.terms,
+    StockIndex: p.stockIndex,
+    Series:     series,
+    Counts:     counts,
+    Shares:     shares,
+  })
+}
+
+func apiHitsHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
+  size, err := strconv.Atoi(paramStr(r, "size", strconv.Itoa(pageSz)))
+  if err != nil || size < 1 || size > maxAPIPageSz {
+    writeJSONError(w, http.StatusBadRequest, "invalid size parameter")
+    return
+  }
+
+  total, hits, err := searcher.highlightSearch(p, size)
+  if err != nil {
+    writeJSONError(w, http.StatusInternalServerError, "hit search error")
+    log.Printf("in apiHitsHandler with search term '%s', highlight search error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+  pages := int(math.Ceil(float64(total) / float64(size)))
+  next := ""
+  if len(hits) > 0 && p.page < pages {
+    next = hits[len(hits)-1].cursor
+  }
+  if hits == nil {
+    hits = []Hit{} // [] rather than null
+  }
+
+  writeJSON(w, http.StatusOK, APIHits{
+    SearchTerm: p.searchTerm,
+    StockIndex: p.stockIndex,
+    Section:    p.section,
+    Total:      total,
+    Page:       p.page,
+    Pages:      pages,
+    Size:       size,
+    Next:       next,
+    Hits:       hits,
+  })
+}
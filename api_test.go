@@ -0,0 +1,98 @@
+// unittests for the JSON API
+package main
+
+import (
+  "testing"
+  "strings"
+  "net/http"
+  "encoding/json"
+  "net/http/httptest"
+)
+
+func TestAPIHits(t *testing.T) {
+  searcher = &fakeSearcher{
+    total: 2*pageSz + 1,
+    hits:  []Hit{{Id: "0000320193-23-000106", Filed: "2012-02-28", Ticker: "ABC",
+                  Name: "Abc Inc", Excerpt: "the <em>cloud</em>", Score: 1.5, cursor: "next"}},
+  }
+  handler := processAPIParameters(apiHitsHandler)
+
+  w := httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/hits?searchterm=cloud&p=2", nil))
+  res := w.Result()
+  defer res.Body.Close()
+  if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+    t.Fatalf("status code %v, content type %s, expected %v JSON.", res.StatusCode,
+      res.Header.Get("Content-Type"), http.StatusOK)
+  }
+  var hits APIHits
+  if err := json.NewDecoder(res.Body).Decode(&hits); err != nil {
+    t.Fatalf("decoding hits: %s.", err)
+  }
+  if hits.Total != 2*pageSz+1 || hits.Page != 2 || hits.Pages != 3 || hits.Size != pageSz ||
+     hits.Next != "next" {
+    t.Fatalf("total %d page %d of %d size %d next %s, expected %d page 2 of 3 size %d.",
+      hits.Total, hits.Page, hits.Pages, hits.Size, hits.Next, 2*pageSz+1, pageSz)
+  }
+  if len(hits.Hits) != 1 || hits.Hits[0].Ticker != "ABC" || hits.Hits[0].Excerpt != "the <em>cloud</em>" {
+    t.Fatalf("hits %v, expected the fake hit.", hits.Hits)
+  }
+
+  // matches are per section searched, and left out when not counted
+  b, _ := json.Marshal(Hit{SectionOccurrences: 3})
+  if !strings.Contains(string(b), `"section_occurrences":3`) {
+    t.Fatalf("hit %s, expected section_occurrences.", b)
+  }
+  if b, _ = json.Marshal(Hit{}); strings.Contains(string(b), "occurrences") {
+    t.Fatalf("hit %s, expected no count.", b)
+  }
+
+  // page size and errors
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/api/v1/hits?searchterm=cloud&size=10", nil))
+  if err := json.NewDecoder(w.Result().Body).Decode(&hits); err != nil || hits.Pages != 4 {
+    t.Fatalf("pages %d, error %v, expected 4 pages of 10.", hits.Pages, err)
+  }
+
+  cases := [][2]string {
+    // request, expected message
+    {"/api/v1/hits?searchterm=cloud&size=500", "invalid size parameter"},
+    {"/api/v1/hits?searchterm=cloud&p=x", "invalid page parameter"},
+    {"/api/v1/hits?searchterm=cloud&fragments=11", "invalid fragments parameter"},
+    {"/api/v1/hits?searchterm=cloud&fragment_size=10", "invalid fragment_size parameter"},
+    {"/api/v1/hits?searchterm=cloud&occurrences=x", "invalid occurrences parameter"},
+    {"/api/v1/hits?searchterm=cloud+AND+%28outage", "invalid search term: missing )"},
+  }
+  for _, c := range cases {
+    w = httptest.NewRecorder()
+    handler(w, httptest.NewRequest(http.MethodGet, c[0], nil))
+    var apiErr APIError
+    if err := json.NewDecoder(w.Result().Body).Decode(&apiErr); err != nil {
+      t.Fatalf("decoding error for %s: %s.", c[0], err)
+    }
+    if w.Code != http.StatusBadRequest || apiErr.Error.Status != http.StatusBadRequest ||
+       apiErr.Error.Message != c[1] {
+      t.Fatalf("%s returned %d %v, expected %d %s.", c[0], w.Code, apiErr,
+        http.StatusBadRequest, c[1])
+    }
+  }
+}
+
+func TestAPIHistogram(t *testing.T) {
+  searcher = &fakeSearcher{total: 7}
+  w := httptest.NewRecorder()
+  processAPIParameters(apiHistogramHandler)(w, httptest.NewRequest(http.MethodGet,
+    "/api/v1/histogram?searchterm=cloud&stockindex=Russell+2000", nil))
+
+  var histogram APIHistogram
+  if err := json.NewDecoder(w.Result().Body).Decode(&histogram); err != nil {
+    t.Fatalf("decoding histogram: %s.", err)
+  }
+  if w.Code != http.StatusOK || histogram.SearchTerm != "cloud" ||
+     histogram.StockIndex != "Russell 2000" || len(histogram.Counts) != len(primarySections) {
+    t.Fatalf("status code %d, histogram %v, expected counts for each section.", w.Code, histogram)
+  }
+  if histogram.Counts[sections[0]]["2024"] != 7 {
+    t.Fatalf("counts %v, expected 7 filings in 2024.", histogram.Counts)
+  }
+}
@@ -0,0 +1,161 @@
+// settings shared by the server and index_builder
+package config
+
+import (
+  "fmt"
+  "strings"
+  "database/sql"
+)
+
+// elasticsearch index and the SQLite database it is built from
+const IndexName = "filings_2024_03_11"
+const DBPath = "filings-2024-03-11.sqlite3"
+
+// the sqlite backend's full-text index in the database, rebuilt on start
+// when missing
+const FTSTable = "filings_fts"
+
+// Item 1A paragraphs that weren't in the company's filing the year before,
+// written by new_risk_builder from the risk factors table
+const RiskFactorsTable = "item1a"
+const NewRiskFactorsTable = "item1a_new"
+
+// a 10-K section that is indexed and searchable
+type Section struct {
+  Name     string // shown to users and passed in the section parameter
+  Field    string // elasticsearch field with the section text
+  Table    string // SQLite table with accession_number and contents columns
+  Required bool   // only filings that have this section are indexed
+  // text copied from another section, searchable on its own but left out
+  // of all sections searches, the filing view and the sections graphed
+  Derived  bool
+}
+
+// indexed sections, in the order they are shown. adding a section here and
+// rerunning index_builder makes it searchable everywhere
+var Sections = []Section {
+  {Name: "1. Business",          Field: "1. Business",          Table: "item1", Required: true},
+  {Name: "1A. Risk Factors",     Field: "1A. Risk Factors",     Table: RiskFactorsTable},
+  {Name: "1A. New Risk Factors", Field: "1A. New Risk Factors", Table: NewRiskFactorsTable,
+   Derived: true},
+  {Name: "3. Legal Proceedings", Field: "3. Legal Proceedings", Table: "item3"},
+  {Name: "7. MD&A",              Field: "7. MD&A",              Table: "item7"},
+  {Name: "7A. Market Risk",      Field: "7A. Market Risk",      Table: "item7a"},
+}
+
+// names of all sections, in order
+func SectionNames() []string {
+  var names []string
+  for _, s := range Sections {
+    names = append(names, s.Name)
+  }
+  return names
+}
+
+// names of the sections that aren't derived from another, in order
+func PrimarySectionNames() []string {
+  var names []string
+  for _, s := range Sections {
+    if !s.Derived {
+      names = append(names, s.Name)
+    }
+  }
+  return names
+}
+
+// look up a section by name
+func FindSection(name string) (Section, bool) {
+  for _, s := range Sections {
+    if s.Name == name {
+      return s, true
+    }
+  }
+  return Section{}, false
+}
+
+// sections whose table is in the database, older databases don't have
+// every section
+func AvailableSections(db *sql.DB) ([]Section, error) {
+  var available []Section
+  for _, s := range Sections {
+    var n int
+    err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?",
+      s.Table).Scan(&n)
+    if err != nil {
+      return available, err
+    }
+    if n > 0 {
+      available = append(available, s)
+    }
+  }
+  return available, nil
+}
+
+// joins of the filings table to each section's table, filings without a
+// required section are left out
+func SectionJoins(sections []Section) string {
+  var joins []string
+  for _, s := range sections {
+    join := "LEFT JOIN"
+    if s.Required {
+      join = "JOIN"
+    }
+    joins = append(joins,
+      fmt.Sprintf("%s %s ON filings.accession_number=%s.accession_number", join, s.Table, s.Table))
+  }
+  return "\n  " + strings.Join(joins, "\n  ")
+}
+
+// companies column with the SIC code, newer databases have it
+const SICColumn = "sic_code"
+
+// an industry sector, the SIC divisions by two digit major group
+type Sector struct {
+  Name  string
+  First int // first and last major groups in the division
+  Last  int
+}
+
+var Sectors = []Sector {
+  {Name: "Agriculture, Forestry and Fishing",    First: 1,  Last: 9},
+  {Name: "Mining",                               First: 10, Last: 14},
+  {Name: "Construction",                         First: 15, Last: 17},
+  {Name: "Manufacturing",                        First: 20, Last: 39},
+  {Name: "Transportation and Public Utilities",  First: 40, Last: 49},
+  {Name: "Wholesale Trade",                      First: 50, Last: 51},
+  {Name: "Retail Trade",                         First: 52, Last: 59},
+  {Name: "Finance, Insurance and Real Estate",   First: 60, Last: 67},
+  {Name: "Services",                             First: 70, Last: 89},
+  {Name: "Public Administration",                First: 91, Last: 97},
+  {Name: "Nonclassifiable Establishments",       First: 99, Last: 99},
+}
+
+// look up a sector by name
+func FindSector(name string) (Sector, bool) {
+  for _, s := range Sectors {
+    if s.Name == name {
+      return s, true
+    }
+  }
+  return Sector{}, false
+}
+
+// whether the companies table has SIC codes
+func HasSICCodes(db *sql.DB) (bool, error) {
+  var n int
+  err := db.QueryRow("SELECT count(*) FROM pragma_table_info('companies') WHERE name=?",
+    SICColumn).Scan(&n)
+  return n > 0, err
+}
+
+// SQL expression for the sector of a SIC code column, empty when the code
+// is missing or not in a division
+func SectorSQL(column string) string {
+  group := fmt.Sprintf("CAST(substr(printf('%%04d', coalesce(%s, '')), 1, 2) AS INTEGER)", column)
+  cases := []string{fmt.Sprintf("WHEN coalesce(%s, '')='' THEN ''", column)}
+  for _, s := range Sectors {
+    cases = append(cases, fmt.Sprintf("WHEN %s BETWEEN %d AND %d THEN '%s'",
+      group, s.First, s.Last, s.Name))
+  }
+  return "CASE " + strings.Join(cases, " ") + " ELSE '' END"
+}
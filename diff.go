@@ -0,0 +1,118 @@
+package main
+
+import (
+  "log"
+  "fmt"
+  "bytes"
+  "strconv"
+  "strings"
+  "net/http"
+  "github.com/kyleleelarson/sec-search/config"
+  "github.com/kyleleelarson/sec-search/textdiff"
+)
+
+// what changed in a section between two of a company's filings, at
+// /company/{ticker}/diff?section=...&from=YYYY&to=YYYY
+
+type DiffParameters struct {
+  ticker  string
+  section string
+  from    int // the last filing with the section each year is compared
+  to      int
+}
+
+type DiffData struct {
+  Ticker   string
+  Section  string
+  Sections []string
+  From     *FilingSection
+  To       *FilingSection
+  Edits    []textdiff.Edit
+  Removed  int // paragraphs
+  Added    int
+}
+
+// /company/{ticker}/diff, other company pages are not found
+func companyHandler(w http.ResponseWriter, r *http.Request) {
+  path := strings.Split(strings.TrimPrefix(r.URL.Path, "/company/"), "/")
+  if len(path) != 2 || path[1] != "diff" {
+    http.NotFound(w, r)
+    return
+  }
+  diffHandler(w, r, path[0])
+}
+
+// the ticker from the path and the query string parameters, to defaults to
+// the last year in the index and from to the year before
+func parseDiffParameters(r *http.Request, ticker string) (*DiffParameters, error) {
+  var (
+    p DiffParameters
+    err error
+  )
+
+  p.ticker = strings.ToUpper(ticker)
+  if !validTicker.MatchString(p.ticker) {
+    return nil, fmt.Errorf("invalid ticker '%s'", p.ticker)
+  }
+
+  p.section = paramStr(r, "section", defaultSection)
+  if _, ok := config.FindSection(p.section); !ok {
+    return nil, fmt.Errorf("invalid section parameter")
+  }
+
+  p.to, err = strconv.Atoi(paramStr(r, "to", strconv.Itoa(currentFacets().LastYear)))
+  if err != nil {
+    return nil, fmt.Errorf("invalid to parameter")
+  }
+  p.from, err = strconv.Atoi(paramStr(r, "from", strconv.Itoa(p.to-1)))
+  if err != nil {
+    return nil, fmt.Errorf("invalid from parameter")
+  }
+  if p.from >= p.to {
+    return nil, fmt.Errorf("from must be before to")
+  }
+  return &p, nil
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request, ticker string) {
+  var buf bytes.Buffer
+
+  p, err := parseDiffParameters(r, ticker)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  log.Printf(",%s,%s,%s,%d,%d\n", r.URL.Path, p.ticker, p.section, p.from, p.to)
+
+  data := DiffData{Ticker: p.ticker, Section: p.section, Sections: sections}
+  data.From, err = searcher.filingSection(p.ticker, p.section, p.from)
+  if err == nil {
+    data.To, err = searcher.filingSection(p.ticker, p.section, p.to)
+  }
+  if err != nil {
+    http.Error(w, "filing search error", http.StatusInternalServerError)
+    log.Printf("in diffHandler for %s, filing search error: %s\n", p.ticker, err.Error())
+    return
+  }
+  if data.From == nil || data.To == nil {
+    missing := p.from
+    if data.From != nil {
+      missing = p.to
+    }
+    http.Error(w, fmt.Sprintf("no %s filing with %s in %d", p.ticker, p.section, missing),
+      http.StatusNotFound)
+    return
+  }
+
+  data.Edits = textdiff.Diff(textdiff.Paragraphs(data.From.Text), textdiff.Paragraphs(data.To.Text))
+  data.Removed, data.Added = textdiff.Count(data.Edits)
+
+  err = templates.ExecuteTemplate(&buf, "diff.html", data)
+  if err != nil {
+    http.Error(w, "template error", http.StatusInternalServerError)
+    log.Printf("in diffHandler for %s, execute template error: %s\n", p.ticker, err.Error())
+    return
+  }
+
+  fmt.Fprintf(w, "%s", buf.String())
+}
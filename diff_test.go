@@ -0,0 +1,53 @@
+// unittests for the section diffs
+package main
+
+import (
+  "strings"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestDiff(t *testing.T) {
+  searcher = &fakeSearcher{}
+
+  w := httptest.NewRecorder()
+  companyHandler(w, httptest.NewRequest(http.MethodGet, "/company/abc/diff?from=2012&to=2014", nil))
+  body := w.Body.String()
+  if w.Code != http.StatusOK || !strings.Contains(body, "Abc Inc (ABC), " + defaultSection) {
+    t.Fatalf("status code %d, body %s, expected ABC's diff.", w.Code, body)
+  }
+  for _, expected := range []string{
+    `<p class="equal">We face competition.</p>`,
+    `<p class="delete">Risks of 2012.</p>`,
+    `<p class="insert">Risks of 2014.</p>`,
+    `<p class="equal">We rely on suppliers.</p>`,
+    "1 paragraphs removed", "1 added",
+  } {
+    if !strings.Contains(body, expected) {
+      t.Fatalf("body %s, expected %s.", body, expected)
+    }
+  }
+
+  cases := []struct {
+    path   string
+    status int
+    body   string
+  }{
+    {"/company/ABC/diff?from=2014&to=2014", http.StatusBadRequest, "from must be before to"},
+    {"/company/ABC/diff?to=20x4", http.StatusBadRequest, "invalid to parameter"},
+    {"/company/ABC/diff?section=Item+9", http.StatusBadRequest, "invalid section parameter"},
+    {"/company/A%3BB/diff", http.StatusBadRequest, "invalid ticker 'A;B'"},
+    {"/company/ABC/diff?from=2010&to=2014", http.StatusNotFound,
+     "no ABC filing with " + defaultSection + " in 2010"},
+    {"/company/ABC/risks", http.StatusNotFound, "404 page not found"},
+  }
+  for _, c := range cases {
+    w = httptest.NewRecorder()
+    companyHandler(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+    body = strings.TrimSpace(w.Body.String())
+    if w.Code != c.status || body != c.body {
+      t.Fatalf("%s returned %d %s, expected %d %s.", c.path, w.Code, body, c.status, c.body)
+    }
+  }
+}
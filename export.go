@@ -0,0 +1,150 @@
+package main
+
+import (
+  "log"
+  "html"
+  "strings"
+  "net/url"
+  "strconv"
+  "net/http"
+  "html/template"
+  "encoding/csv"
+  "encoding/json"
+)
+
+// every hit for a search as CSV, not just the pages shown in the table,
+// and the graph's counts as CSV or JSON
+
+var exportHeader = []string{"ticker", "company", "filed", "url", "section", "excerpt"}
+
+// excerpts without the <em> tags and html escaping, in one column
+func excerptText(excerpts []template.HTML) string {
+  var texts []string
+  for _, excerpt := range excerpts {
+    s := strings.ReplaceAll(string(excerpt), "<em>", "")
+    s = strings.ReplaceAll(s, "</em>", "")
+    texts = append(texts, html.UnescapeString(s))
+  }
+  return strings.Join(texts, " ... ")
+}
+
+// link to the export of the table's search, page is left out
+func exportURL(p *Parameters) string {
+  v := url.Values{}
+  v.Set("searchterm", p.searchTerm)
+  v.Set("stockindex", p.stockIndex)
+  if len(p.tickers) > 0 {
+    v.Set("tickers", strings.Join(p.tickers, ","))
+  }
+  if p.sector != "" {
+    v.Set("sector", p.sector)
+  }
+  v.Set("section", p.section)
+  v.Set("fragments", strconv.Itoa(p.fragments))
+  v.Set("fragment_size", strconv.Itoa(p.fragmentSize))
+  if p.from != "" || p.to != "" {
+    v.Set("from", p.from)
+    v.Set("to", p.to)
+  } else {
+    v.Set("year", p.year)
+  }
+  return "/export.csv?" + v.Encode()
+}
+
+// rows are written as they arrive, so an error after the first can only
+// be logged and the download is cut short
+func exportHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
+  var cw *csv.Writer
+  start := func() error {
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    w.Header().Set("Content-Disposition", `attachment; filename="sec-search.csv"`)
+    cw = csv.NewWriter(w)
+    return cw.Write(exportHeader)
+  }
+
+  err := searcher.exportHits(p, func(hit Hit) error {
+    if cw == nil {
+      if err := start(); err != nil {
+        return err
+      }
+    }
+    return cw.Write([]string{hit.Ticker, hit.Name, hit.Filed, hit.Url, hit.Section,
+      excerptText(hit.Excerpts)})
+  })
+  if err != nil && cw == nil {
+    http.Error(w, "export error", http.StatusInternalServerError)
+    log.Printf("in exportHandler with search term '%s', export error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+  if err != nil {
+    log.Printf("in exportHandler with search term '%s', export cut short: %s\n",
+      p.searchTerm, err.Error())
+  }
+
+  if cw == nil {
+    start() // no hits, just the header
+  }
+  cw.Flush()
+  if err = cw.Error(); err != nil {
+    log.Printf("in exportHandler with search term '%s', write error: %s\n",
+      p.searchTerm, err.Error())
+  }
+}
+
+// a row per year with a column of counts per series, or percents when
+// normalizing, like the graph
+func histogramRows(series []string, counts map[string](map[string]int),
+  shares map[string](map[string]float64)) [][]string {
+
+  rows := [][]string{append([]string{"year"}, series...)}
+  for _, year := range currentFacets().years() {
+    row := []string{year}
+    for _, s := range series {
+      // zero if not in map
+      if shares != nil {
+        row = append(row, strconv.FormatFloat(shares[s][year], 'f', 2, 64))
+      } else {
+        row = append(row, strconv.Itoa(counts[s][year]))
+      }
+    }
+    rows = append(rows, row)
+  }
+  return rows
+}
+
+func histogramExportHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
+  format := paramStr(r, "format", "csv")
+  if format != "csv" && format != "json" {
+    http.Error(w, "invalid format parameter", http.StatusBadRequest)
+    return
+  }
+
+  series, counts, shares, err := histogram(p)
+  if err != nil {
+    http.Error(w, "histogram search error", http.StatusInternalServerError)
+    log.Printf("in histogramExportHandler with search term '%s', histogram search error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+
+  w.Header().Set("Content-Disposition", `attachment; filename="sec-search-histogram.` + format + `"`)
+  if format == "json" {
+    w.Header().Set("Content-Type", "application/json")
+    err = json.NewEncoder(w).Encode(APIHistogram{
+      SearchTerm: p.searchTerm,
+      Terms:      p.terms,
+      StockIndex: p.stockIndex,
+      Series:     series,
+      Counts:     counts,
+      Shares:     shares,
+    })
+  } else {
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    err = csv.NewWriter(w).WriteAll(histogramRows(series, counts, shares))
+  }
+  if err != nil {
+    log.Printf("in histogramExportHandler with search term '%s', write error: %s\n",
+      p.searchTerm, err.Error())
+  }
+}
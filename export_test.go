@@ -0,0 +1,144 @@
+// unittests for the CSV export
+package main
+
+import (
+  "testing"
+  "strings"
+  "strconv"
+  "net/http"
+  "html/template"
+  "encoding/csv"
+  "encoding/json"
+  "net/http/httptest"
+)
+
+func TestExport(t *testing.T) {
+  searcher = &fakeSearcher{
+    total: 2,
+    hits:  []Hit{
+      {Filed: "2013-02-27", Ticker: "ABC", Name: "Abc, Inc", Url: "https://sec.gov/2",
+       Section: sections[0], Excerpt: "<em>cloud</em> &amp; &quot;storage&quot;",
+       Excerpts: []template.HTML{"<em>cloud</em> &amp; &quot;storage&quot;", "private <em>cloud</em>"}},
+      {Filed: "2012-02-28", Ticker: "XYZ", Name: "Xyz Corp", Url: "https://sec.gov/1",
+       Section: sections[0], Excerpt: "the <em>cloud</em>", Excerpts: []template.HTML{"the <em>cloud</em>"}},
+    },
+  }
+  w := httptest.NewRecorder()
+  processParameters(exportHandler)(w, httptest.NewRequest(http.MethodGet,
+    "/export.csv?searchterm=cloud&from=2012&to=2013", nil))
+  if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+    t.Fatalf("status code %d, content type %s, expected CSV.", w.Code,
+      w.Header().Get("Content-Type"))
+  }
+
+  records, err := csv.NewReader(w.Body).ReadAll()
+  if err != nil {
+    t.Fatalf("reading CSV: %s.", err)
+  }
+  expected := [][]string {
+    exportHeader,
+    {"ABC", "Abc, Inc", "2013-02-27", "https://sec.gov/2", sections[0], `cloud & "storage" ... private cloud`},
+    {"XYZ", "Xyz Corp", "2012-02-28", "https://sec.gov/1", sections[0], "the cloud"},
+  }
+  if len(records) != len(expected) {
+    t.Fatalf("records %v, expected %v.", records, expected)
+  }
+  for i := range expected {
+    if strings.Join(records[i], "|") != strings.Join(expected[i], "|") {
+      t.Fatalf("record %v, expected %v.", records[i], expected[i])
+    }
+  }
+
+  // the table links to the export of its search, range or year
+  p := Parameters{searchTerm: "cloud AND outage", stockIndex: "S&P 500", section: sections[1],
+                  year: "2012", page: 3, fragments: 2, fragmentSize: 300}
+  expectedURL := "/export.csv?fragment_size=300&fragments=2&searchterm=cloud+AND+outage&section=" +
+    strings.ReplaceAll(sections[1], " ", "+") + "&stockindex=S%26P+500&year=2012"
+  if u := exportURL(&p); u != expectedURL {
+    t.Fatalf("exportURL = %s, expected %s.", u, expectedURL)
+  }
+}
+
+func TestHistogramExport(t *testing.T) {
+  searcher = &fakeSearcher{total: 7}
+  handler := processParameters(histogramExportHandler)
+
+  w := httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/histogram?searchterm=cloud", nil))
+  records, err := csv.NewReader(w.Body).ReadAll()
+  if err != nil {
+    t.Fatalf("reading CSV: %s.", err)
+  }
+  years := currentFacets().years()
+  if len(records) != len(years)+1 || strings.Join(records[0], "|") !=
+     "year|" + strings.Join(primarySections, "|") {
+    t.Fatalf("records %v, expected a header and a row per year.", records)
+  }
+  for _, record := range records[1:] {
+    expected := "0"
+    if record[0] == strconv.Itoa(yearUpperBound) {
+      expected = "7"
+    }
+    if record[1] != expected || record[len(record)-1] != expected {
+      t.Fatalf("record %v, expected counts of %s.", record, expected)
+    }
+  }
+
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/histogram?searchterm=cloud&format=json", nil))
+  var histogram APIHistogram
+  if err = json.NewDecoder(w.Body).Decode(&histogram); err != nil ||
+     histogram.Counts[sections[0]][strconv.Itoa(yearUpperBound)] != 7 {
+    t.Fatalf("histogram %v, error %v, expected the counts as JSON.", histogram, err)
+  }
+
+  // percents of the fake four times as many filings
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet,
+    "/histogram?searchterm=cloud&format=json&normalize=true", nil))
+  histogram = APIHistogram{}
+  if err = json.NewDecoder(w.Body).Decode(&histogram); err != nil ||
+     histogram.Shares[sections[0]][strconv.Itoa(yearUpperBound)] != 25 ||
+     histogram.Counts[sections[0]][strconv.Itoa(yearUpperBound)] != 7 {
+    t.Fatalf("histogram %v, error %v, expected counts and 25 percent shares.", histogram, err)
+  }
+
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/histogram?searchterm=cloud&normalize=true", nil))
+  records, err = csv.NewReader(w.Body).ReadAll()
+  if err != nil || records[len(records)-1][1] != "25.00" {
+    t.Fatalf("records %v, error %v, expected percents.", records, err)
+  }
+
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/histogram?searchterm=cloud&normalize=maybe", nil))
+  if w.Code != http.StatusBadRequest {
+    t.Fatalf("status code %d, expected %d.", w.Code, http.StatusBadRequest)
+  }
+
+  // a column per term when comparing
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet,
+    "/histogram?searchterm=inflation&searchterm=supply+chain", nil))
+  records, err = csv.NewReader(w.Body).ReadAll()
+  if err != nil || strings.Join(records[0], "|") != "year|inflation|supply chain" ||
+     strings.Join(records[len(records)-1][1:], "|") != "7|7" {
+    t.Fatalf("records %v, error %v, expected counts per term.", records, err)
+  }
+
+  // a column per sector with a sector breakdown
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet,
+    "/histogram?searchterm=cloud&breakdown=sector&normalize=true", nil))
+  records, err = csv.NewReader(w.Body).ReadAll()
+  if err != nil || strings.Join(records[0], "|") != "year|Services" ||
+     records[len(records)-1][1] != "25.00" {
+    t.Fatalf("records %v, error %v, expected percents per sector.", records, err)
+  }
+
+  w = httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, "/histogram?searchterm=cloud&format=xml", nil))
+  if w.Code != http.StatusBadRequest {
+    t.Fatalf("status code %d, expected %d.", w.Code, http.StatusBadRequest)
+  }
+}
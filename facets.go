@@ -0,0 +1,111 @@
+package main
+
+import (
+  "fmt"
+  "log"
+  "sort"
+  "sync"
+  "time"
+  "strconv"
+)
+
+// how often to check the index for new filing years and stock indices
+const facetsRefresh = time.Hour
+
+// filing years, stock indices and sectors found in the index, these drive
+// the year, stock index and sector choices and the graph x-axis
+type Facets struct {
+  FirstYear    int
+  LastYear     int
+  StockIndices []string
+  Sectors      []string // none for indices without SIC codes
+}
+
+// what's in the index until the search backend is first asked
+var (
+  facets = Facets{
+    FirstYear:    yearLowerBound,
+    LastYear:     yearUpperBound,
+    StockIndices: []string{"S&P 500", "Russell 2000"},
+  }
+  facetsMu sync.RWMutex
+)
+
+func currentFacets() Facets {
+  facetsMu.RLock()
+  defer facetsMu.RUnlock()
+  return facets
+}
+
+// every filing year from first to last
+func (f Facets) years() []string {
+  var years []string
+  for y := f.FirstYear; y <= f.LastYear; y++ {
+    years = append(years, strconv.Itoa(y))
+  }
+  return years
+}
+
+// facets from the first and last filing dates and the stock index and sector
+// values, filings without a stock index or sector are left out
+func newFacets(first, last string, stockIndices, sectors []string) (*Facets, error) {
+  if len(first) < 4 || len(last) < 4 {
+    return nil, fmt.Errorf("no filing dates, first '%s' last '%s'", first, last)
+  }
+  var (
+    f Facets
+    err error
+  )
+  if f.FirstYear, err = strconv.Atoi(first[:4]); err != nil {
+    return nil, err
+  }
+  if f.LastYear, err = strconv.Atoi(last[:4]); err != nil {
+    return nil, err
+  }
+  for _, s := range stockIndices {
+    if s != "" {
+      f.StockIndices = append(f.StockIndices, s)
+    }
+  }
+  for _, s := range sectors {
+    if s != "" {
+      f.Sectors = append(f.Sectors, s)
+    }
+  }
+  sort.Strings(f.Sectors)
+  return &f, nil
+}
+
+// ask the search backend for the current facets, keeping the previous
+// ones if that fails
+func updateFacets(s Searcher) {
+  f, err := s.facets()
+  if err != nil {
+    log.Printf("error updating years and stock indices: %s\n", err)
+    return
+  }
+  if f.LastYear < f.FirstYear || len(f.StockIndices) == 0 {
+    log.Printf("no filings found updating years and stock indices, keeping %v\n",
+      currentFacets())
+    return
+  }
+
+  // default stock index first, the rest alphabetically
+  sort.Slice(f.StockIndices, func(i, j int) bool {
+    a, b := f.StockIndices[i], f.StockIndices[j]
+    if a == defaultStockIndex || b == defaultStockIndex {
+      return a == defaultStockIndex
+    }
+    return a < b
+  })
+
+  facetsMu.Lock()
+  facets = *f
+  facetsMu.Unlock()
+}
+
+func refreshFacets(s Searcher, interval time.Duration) {
+  for range time.Tick(interval) {
+    updateFacets(s)
+  }
+}
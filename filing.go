@@ -0,0 +1,68 @@
+package main
+
+import (
+  "log"
+  "fmt"
+  "bytes"
+  "regexp"
+  "strings"
+  "net/http"
+)
+
+// a filing's sections to read in context, at /filing/{accession}, with
+// every match of the optional searchterm highlighted
+
+var validAccession = regexp.MustCompile(`^[0-9A-Za-z\-]{1,25}$`)
+
+type FilingData struct {
+  *Filing
+  SearchTerm string
+  Matches    int // in every section
+}
+
+func filingHandler(w http.ResponseWriter, r *http.Request) {
+  var (
+    buf bytes.Buffer
+    q *queryNode
+    err error
+  )
+
+  id := strings.TrimPrefix(r.URL.Path, "/filing/")
+  if !validAccession.MatchString(id) {
+    http.NotFound(w, r)
+    return
+  }
+  searchTerm := r.FormValue("searchterm")
+  if strings.TrimSpace(searchTerm) != "" {
+    if q, err = parseQuery(searchTerm); err != nil {
+      http.Error(w, fmt.Sprintf("invalid search term: %s", err), http.StatusBadRequest)
+      return
+    }
+  }
+  log.Printf(",%s,'%s'\n", r.URL.Path, searchTerm)
+
+  filing, err := searcher.filing(id, q)
+  if err != nil {
+    http.Error(w, "filing search error", http.StatusInternalServerError)
+    log.Printf("in filingHandler for %s, filing search error: %s\n", id, err.Error())
+    return
+  }
+  if filing == nil {
+    http.Error(w, fmt.Sprintf("no filing %s", id), http.StatusNotFound)
+    return
+  }
+
+  data := FilingData{Filing: filing, SearchTerm: searchTerm}
+  for _, s := range filing.Sections {
+    data.Matches += s.Matches
+  }
+
+  err = templates.ExecuteTemplate(&buf, "filing.html", data)
+  if err != nil {
+    http.Error(w, "template error", http.StatusInternalServerError)
+    log.Printf("in filingHandler for %s, execute template error: %s\n", id, err.Error())
+    return
+  }
+
+  fmt.Fprintf(w, "%s", buf.String())
+}
@@ -0,0 +1,58 @@
+// unittests for the filing page
+package main
+
+import (
+  "strings"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestFiling(t *testing.T) {
+  searcher = &fakeSearcher{}
+
+  w := httptest.NewRecorder()
+  filingHandler(w, httptest.NewRequest(http.MethodGet, "/filing/1?searchterm=outage+OR+cloud", nil))
+  body := w.Body.String()
+  if w.Code != http.StatusOK || !strings.Contains(body, "Abc Inc (ABC), filed 2012-02-28") {
+    t.Fatalf("status code %d, body %s, expected the filing.", w.Code, body)
+  }
+  for _, expected := range []string{
+    "Match <span id=\"matchNum\">0</span> of 2",
+    "Item " + sections[0] + ", 0 matches",
+    "Item " + sections[1] + ", 2 matches",
+    "An <em>outage</em> of our <em>cloud</em> & more.",
+  } {
+    if !strings.Contains(body, expected) {
+      t.Fatalf("body %s, expected %s.", body, expected)
+    }
+  }
+
+  // without a search term the sections are shown without counts
+  w = httptest.NewRecorder()
+  filingHandler(w, httptest.NewRequest(http.MethodGet, "/filing/1", nil))
+  body = w.Body.String()
+  if w.Code != http.StatusOK || strings.Contains(body, "matches</h3>") ||
+     !strings.Contains(body, "An outage of our cloud &amp; more.") {
+    t.Fatalf("status code %d, body %s, expected the sections without matches.", w.Code, body)
+  }
+
+  cases := []struct {
+    path   string
+    status int
+    body   string
+  }{
+    {"/filing/2", http.StatusNotFound, "no filing 2"},
+    {"/filing/1?searchterm=cloud+AND+%28outage", http.StatusBadRequest,
+     "invalid search term: missing )"},
+    {"/filing/1/risks", http.StatusNotFound, "404 page not found"},
+  }
+  for _, c := range cases {
+    w = httptest.NewRecorder()
+    filingHandler(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+    body = strings.TrimSpace(w.Body.String())
+    if w.Code != c.status || body != c.body {
+      t.Fatalf("%s returned %d %s, expected %d %s.", c.path, w.Code, body, c.status, c.body)
+    }
+  }
+}
@@ -2,13 +2,16 @@ module github.com/kyleleelarson/sec-search
 
 go 1.21.4
 
+require (
+	github.com/elastic/go-elasticsearch/v8 v8.12.0
+	github.com/go-echarts/go-echarts/v2 v2.3.3
+	github.com/mattn/go-sqlite3 v1.14.22
+)
+
 require (
 	github.com/elastic/elastic-transport-go/v8 v8.4.0 // indirect
-	github.com/elastic/go-elasticsearch/v8 v8.12.0 // indirect
-	github.com/go-echarts/go-echarts/v2 v2.3.3 // indirect
 	github.com/go-logr/logr v1.3.0 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
 	go.opentelemetry.io/otel v1.21.0 // indirect
 	go.opentelemetry.io/otel/metric v1.21.0 // indirect
 	go.opentelemetry.io/otel/trace v1.21.0 // indirect
@@ -5,6 +5,7 @@ import (
   "io"
   "fmt"
   "bytes"
+  "strings"
   "html/template"
 	"github.com/go-echarts/go-echarts/v2/opts"
 	"github.com/go-echarts/go-echarts/v2/types"
@@ -38,6 +39,9 @@ func (r *embedRender) Render(w io.Writer) error {
         "safeJS": func(s interface{}) template.JS {
           return template.JS(fmt.Sprint(s)) // concatenates and casts to type JS
         },
+        "stockIndices": templateFuncs["stockIndices"],
+        "allSections":  templateFuncs["allSections"],
+        "sectors":      templateFuncs["sectors"],
       }).
       Parse(baseTpl),
     )
@@ -45,16 +49,53 @@ func (r *embedRender) Render(w io.Writer) error {
   return err
 }
 
-func renderGraph(counts map[string](map[string]int), p *Parameters, buf *bytes.Buffer) error {
+// tickers filter listed in the subtitle up to this many, otherwise counted
+const maxTickersShown = 8
+
+// a bar series per section or sector, stacked, or per search term when
+// comparing, side by side. percents of filings are also side by side as they
+// don't add up across sections
+func renderGraph(series []string, counts map[string](map[string]int),
+  shares map[string](map[string]float64), p *Parameters, buf *bytes.Buffer) error {
+
   barData := make(map[string]([]opts.BarData))
+  years := currentFacets().years()
 
-  for _, section := range sections {
+  for _, s := range series {
     var barValues []opts.BarData
     for _, year := range years {
-      count := counts[section][year] // zero if not in map
-      barValues = append(barValues, opts.BarData{Value: count})
+      // zero if not in map
+      if shares != nil {
+        barValues = append(barValues, opts.BarData{Value: shares[s][year]})
+      } else {
+        barValues = append(barValues, opts.BarData{Value: counts[s][year]})
+      }
     }
-      barData[section] = barValues
+      barData[s] = barValues
+  }
+
+  title, subtitle, yAxis := p.searchTerm, p.stockIndex, "Filings"
+  if len(p.tickers) > maxTickersShown {
+    subtitle = fmt.Sprintf("%d tickers", len(p.tickers))
+  } else if len(p.tickers) > 0 {
+    subtitle = strings.Join(p.tickers, ", ")
+  }
+  if p.sector != "" {
+    subtitle = subtitle + ", " + p.sector
+  }
+  if p.metric == companiesMetric {
+    yAxis = "Companies"
+  }
+  if len(p.terms) > 1 {
+    title = strings.Join(p.terms, " vs ")
+    subtitle = subtitle + ", in any section"
+  }
+  if p.breakdown == sectorBreakdown {
+    subtitle = subtitle + ", by sector in any section"
+  }
+  if shares != nil {
+    subtitle = subtitle + ", share of " + strings.ToLower(yAxis)
+    yAxis = "% of " + strings.ToLower(yAxis)
   }
 
 	// create a new bar instance
@@ -63,20 +104,24 @@ func renderGraph(counts map[string](map[string]int), p *Parameters, buf *bytes.B
 	// set some global options like Title/Legend/ToolTip or anything else
 	bar.SetGlobalOptions(
     charts.WithTitleOpts(opts.Title{
-      Title:    p.searchTerm,
-      Subtitle: p.stockIndex,
+      Title:    title,
+      Subtitle: subtitle,
     }),
     charts.WithLegendOpts(opts.Legend{Top: "bottom", Show: true}),
+    charts.WithYAxisOpts(opts.YAxis{Name: yAxis}),
 		charts.WithInitializationOpts(opts.Initialization{Theme: types.ThemeWesteros}),
 	)
 
-	// Put data into instance
-	bar.SetXAxis(years).
-		AddSeries(sections[0], barData[sections[0]]).
-		AddSeries(sections[1], barData[sections[1]]).
-    SetSeriesOptions(charts.WithBarChartOpts(opts.BarChart{
-		  Stack: "stackA",
-		}))
+	// Put data into instance, one series per section, sector or term
+	bar.SetXAxis(years)
+  for _, s := range series {
+    bar.AddSeries(s, barData[s])
+  }
+  if shares == nil && len(p.terms) < 2 {
+    bar.SetSeriesOptions(charts.WithBarChartOpts(opts.BarChart{
+      Stack: "stackA",
+    }))
+  }
 
   err := bar.Render(buf)
   return err
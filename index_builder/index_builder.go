@@ -13,39 +13,55 @@ import (
   _ "github.com/mattn/go-sqlite3"
   "github.com/elastic/go-elasticsearch/v8"
   "github.com/elastic/go-elasticsearch/v8/esapi"
+  "github.com/kyleleelarson/sec-search/config"
 )
 
-const indexName = "filings_2024_03_11"
-const dbPath = "filings-2024-03-11.sqlite3"
-const selectString = `
+const indexName = config.IndexName
+const dbPath = config.DBPath
+const selectColumns = `
   SELECT 
     companies.ticker, 
     companies.name, 
     coalesce(companies.index_membership, ''), 
     filings.accession_number, 
     filings.filed_date, 
-    filings.link_10k, 
-    item1.contents AS item1, 
-    coalesce(item1a.contents, '') AS item1a
+    filings.link_10k`
+
+// select filings with the company's SIC code and sector, empty without
+// SIC codes, and the contents of each section, empty if a filing doesn't have it
+func selectString(sections []config.Section, hasSIC bool) string {
+  s := selectColumns
+  if hasSIC {
+    s += fmt.Sprintf(",\n    coalesce(companies.%s, ''),\n    %s",
+      config.SICColumn, config.SectorSQL("companies." + config.SICColumn))
+  } else {
+    s += ",\n    '',\n    ''"
+  }
+  for _, section := range sections {
+    s += fmt.Sprintf(",\n    coalesce(%s.contents, '') AS %s", section.Table, section.Table)
+  }
+  return s + `
   FROM companies 
-  JOIN filings ON companies.ticker=filings.ticker
-  JOIN item1 ON filings.accession_number=item1.accession_number
-  LEFT JOIN item1a ON filings.accession_number=item1a.accession_number
+  JOIN filings ON companies.ticker=filings.ticker` + config.SectionJoins(sections) + `
   WHERE substr(filings.filed_date,1,4)>='2005'`
-
-type QueryResult struct {
-  Ticker     string 
-  Name       string
-  StockIndex string
-  Filed      string
-  Url        string
-  Item1      string `json:"1. Business"`
-  Item1a     string `json:"1A. Risk Factors"`
 }
 
+// document fields, Ticker, Name, StockIndex, Filed, Url, SIC and Sector when
+// known and each section's field the filing has
+type QueryResult map[string]string
+
 
 var es *elasticsearch.Client
 
+func findSection(sections []config.Section, name string) (config.Section, bool) {
+  for _, s := range sections {
+    if s.Name == name {
+      return s, true
+    }
+  }
+  return config.Section{}, false
+}
+
 func clientInit() {
   var err error
 
@@ -155,8 +171,27 @@ func main() {
 		log.Fatalf("Updating index settings returns: %d", res.StatusCode)
 	}
 
+  // index the sections this database has
+  sections, err := config.AvailableSections(db)
+  if err != nil {
+		log.Fatalf("Error listing section tables: %s", err)
+	}
+  for _, section := range config.Sections {
+    if _, ok := findSection(sections, section.Name); !ok {
+      log.Printf("No %s table, skipping section %s\n", section.Table, section.Name)
+    }
+  }
+
+  hasSIC, err := config.HasSICCodes(db)
+  if err != nil {
+		log.Fatalf("Error checking for SIC codes: %s", err)
+	}
+  if !hasSIC {
+    log.Printf("No companies.%s column, indexing without sectors\n", config.SICColumn)
+  }
+
 	// query db and index documents
-  selectSt, err := db.Prepare(selectString)
+  selectSt, err := db.Prepare(selectString(sections, hasSIC))
 	if err != nil {
 		log.Fatalf("Error preparing statement: %s", err)
 	}
@@ -168,14 +203,34 @@ func main() {
   i := 0
 	for row.Next() {
     i+=1
-    var qr QueryResult
+    var ticker, name, stockIndex, filed, url, sic, sector string
     var id string // use accession_number for id
-    err = row.Scan(&qr.Ticker, &qr.Name, &qr.StockIndex, &id, &qr.Filed, 
-                   &qr.Url, &qr.Item1, &qr.Item1a) 
+    contents := make([]string, len(sections))
+    dest := []any{&ticker, &name, &stockIndex, &id, &filed, &url, &sic, &sector}
+    for j := range contents {
+      dest = append(dest, &contents[j])
+    }
+    err = row.Scan(dest...) 
     if err != nil {
       log.Fatalf("Error scanning row: %s", err)
     }
 
+    qr := QueryResult{"Ticker": ticker, "Name": name, "StockIndex": stockIndex,
+                      "Filed": filed, "Url": url}
+    if sic != "" {
+      qr["SIC"] = sic
+    }
+    if sector != "" {
+      qr["Sector"] = sector
+    }
+    // sections a filing doesn't have are left out, so the server can
+    // count the filings with each section using exists queries
+    for j, section := range sections {
+      if contents[j] != "" {
+        qr[section.Field] = contents[j]
+      }
+    }
+
     ids = append(ids, id)
     qrs = append(qrs, qr)
 
@@ -0,0 +1,129 @@
+package main
+
+import (
+  "log"
+  "fmt"
+  "strings"
+  "strconv"
+  "database/sql"
+  _ "github.com/mattn/go-sqlite3"
+  "github.com/kyleleelarson/sec-search/config"
+  "github.com/kyleleelarson/sec-search/textdiff"
+)
+
+// finds the risk factor paragraphs each filing added since the company's
+// last filing the year before and writes them to the new risk factors
+// table, searchable as a section once index_builder is rerun or the sqlite
+// backend restarts
+
+const dbPath = config.DBPath
+
+// paragraphs at least this similar, by words in common, are the same risk
+const similarity = 0.8
+
+// filings with risk factors, each company's in the order filed
+var selectString = fmt.Sprintf(`
+  SELECT
+    filings.ticker,
+    filings.accession_number,
+    substr(filings.filed_date,1,4),
+    %[1]s.contents
+  FROM filings
+  JOIN %[1]s ON filings.accession_number=%[1]s.accession_number
+  WHERE coalesce(%[1]s.contents, '')!=''
+  ORDER BY filings.ticker, filings.filed_date, filings.accession_number`, config.RiskFactorsTable)
+
+// a filing's new paragraphs
+type NewRisks struct {
+  id         string // accession number
+  paragraphs []string
+}
+
+func main() {
+  // mode=rw so a wrong path is an error instead of a new empty database
+  db, err := sql.Open("sqlite3", "file:" + dbPath + "?mode=rw")
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+  defer db.Close()
+
+  row, err := db.Query(selectString)
+	if err != nil {
+		log.Fatalf("Error querying database: %s", err)
+	}
+
+  var (
+    found []NewRisks
+    ticker string
+    // the company's paragraphs in its last filing of each year
+    lastFiling map[int][]string
+  )
+  i := 0
+  for row.Next() {
+    i+=1
+    var t, id, yearStr, contents string
+    if err = row.Scan(&t, &id, &yearStr, &contents); err != nil {
+      log.Fatalf("Error scanning row: %s", err)
+    }
+    year, err := strconv.Atoi(yearStr)
+    if err != nil {
+      log.Fatalf("Error reading filed year of %s: %s", id, err)
+    }
+    if t != ticker {
+      ticker, lastFiling = t, make(map[int][]string)
+    }
+
+    paragraphs := textdiff.Paragraphs(contents)
+    // without a filing the year before every paragraph would be new
+    if prior, ok := lastFiling[year-1]; ok {
+      if added := textdiff.Added(prior, paragraphs, similarity); len(added) > 0 {
+        found = append(found, NewRisks{id: id, paragraphs: added})
+      }
+    }
+    lastFiling[year] = paragraphs
+
+    if i % 1000 == 0 {
+      log.Println(i)
+    }
+  }
+  if err = row.Err(); err != nil {
+    log.Fatalf("Error reading rows: %s", err)
+  }
+  row.Close()
+  log.Printf("%d filings, %d with new risk factors\n", i, len(found))
+
+  // replace the table, filings without new paragraphs are left out so the
+  // section is missing from them like any other
+  tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("Error starting transaction: %s", err)
+	}
+  for _, statement := range []string{
+    "DROP TABLE IF EXISTS " + config.NewRiskFactorsTable,
+    "CREATE TABLE " + config.NewRiskFactorsTable + " (accession_number TEXT PRIMARY KEY, contents TEXT)",
+  } {
+    if _, err = tx.Exec(statement); err != nil {
+      log.Fatalf("Error creating %s: %s", config.NewRiskFactorsTable, err)
+    }
+  }
+  insertSt, err := tx.Prepare("INSERT INTO " + config.NewRiskFactorsTable + " VALUES (?, ?)")
+	if err != nil {
+		log.Fatalf("Error preparing statement: %s", err)
+	}
+  for _, f := range found {
+    if _, err = insertSt.Exec(f.id, strings.Join(f.paragraphs, "\n")); err != nil {
+      log.Fatalf("Error inserting new risk factors of %s: %s", f.id, err)
+    }
+  }
+  insertSt.Close()
+
+  // the sqlite backend builds it again with the new section on start
+  if _, err = tx.Exec("DROP TABLE IF EXISTS " + config.FTSTable); err != nil {
+    log.Fatalf("Error dropping %s: %s", config.FTSTable, err)
+  }
+  if err = tx.Commit(); err != nil {
+    log.Fatalf("Error committing %s: %s", config.NewRiskFactorsTable, err)
+  }
+  log.Printf("wrote %s, rerun index_builder to search it in elasticsearch\n",
+    config.NewRiskFactorsTable)
+}
@@ -3,10 +3,13 @@ package main
 
 import (
   "io"
+  "reflect"
   "testing"
   "strings"
   "strconv"
   "net/http"
+  "html/template"
+  "encoding/json"
   "net/http/httptest"
 )
 
@@ -53,35 +56,56 @@ var processedP Parameters
 func testHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
   // set processedP parameters with those passed in
   processedP.searchTerm = p.searchTerm
+  processedP.terms      = p.terms
+  processedP.tab        = p.tab
   processedP.stockIndex = p.stockIndex
   processedP.section    = p.section
   processedP.year       = p.year
   processedP.page       = p.page
+  processedP.from       = p.from
+  processedP.to         = p.to
+  processedP.cursor     = p.cursor
+  processedP.normalize  = p.normalize
+  processedP.metric     = p.metric
+  processedP.tickers    = p.tickers
+  processedP.sector     = p.sector
+  processedP.breakdown  = p.breakdown
+  processedP.fragments  = p.fragments
+  processedP.fragmentSize = p.fragmentSize
+  processedP.occurrences = p.occurrences
 }
 
 func TestProcessParameters(t *testing.T) {
   searchTerm := "artificial intelligence"
   page, _ := strconv.Atoi(defaultPage)
+  defaultYear := strconv.Itoa(currentFacets().LastYear) // latest year in the index
   w := httptest.NewRecorder()
   handler := processParameters(testHandler)
 
   // test all defaults
   reqStr := "/search?searchterm=" + strings.Replace(searchTerm, " ", "+", -1)
-  expectedP := Parameters{searchTerm, defaultStockIndex, defaultSection, defaultYear, page}
+  expectedP := Parameters{searchTerm: searchTerm, terms: []string{searchTerm},
+                          stockIndex: defaultStockIndex,
+                          section: defaultSection, year: defaultYear, page: page,
+                          metric: filingsMetric, breakdown: sectionBreakdown,
+                         fragments: 1, fragmentSize: 200}
   req := httptest.NewRequest(http.MethodGet, reqStr, nil)
   handler(w, req)
-  if processedP != expectedP {
+  if !reflect.DeepEqual(processedP, expectedP) {
     t.Fatalf("processedP = %v, expectedP %v.", processedP, expectedP)
   }
 
   // test custom inputs
-  expectedP = Parameters{searchTerm, "RUSSELL2000", "Item1a", "2012", 2}
+  expectedP = Parameters{searchTerm: searchTerm, terms: []string{searchTerm},
+                         stockIndex: "RUSSELL2000", section: "Item1a",
+                         year: "2012", page: 2, metric: filingsMetric, breakdown: sectionBreakdown,
+                         fragments: 1, fragmentSize: 200}
   pageStr := strconv.Itoa(expectedP.page)
   reqStr = reqStr + "&stockindex=" + expectedP.stockIndex + "&section=" + expectedP.section + 
            "&year=" + expectedP.year + "&p=" + pageStr 
   req = httptest.NewRequest(http.MethodGet, reqStr, nil)
   handler(w, req)
-  if processedP != expectedP {
+  if !reflect.DeepEqual(processedP, expectedP) {
     t.Fatalf("processedP = %v, expectedP %v.", processedP, expectedP)
   }
 
@@ -100,4 +124,355 @@ func TestProcessParameters(t *testing.T) {
   if body != expectedBody {
     t.Fatalf("body %v, expected %v.", body, expectedBody)
   }
+
+  // test malformed search term
+  w = httptest.NewRecorder()
+  req = httptest.NewRequest(http.MethodGet, "/search?searchterm=cloud+AND+%28outage", nil)
+  handler(w, req)
+  res = w.Result()
+  defer res.Body.Close()
+  if res.StatusCode != http.StatusBadRequest {
+    t.Fatalf("status code %v, expected %v.", res.StatusCode, http.StatusBadRequest)
+  }
+  b, _ = io.ReadAll(res.Body)
+  body = strings.Join(strings.Fields(string(b)), " ")
+  expectedBody = "invalid search term: missing )"
+  if body != expectedBody {
+    t.Fatalf("body %v, expected %v.", body, expectedBody)
+  }
+
+  // test from, to range and an invalid range
+  expectedP = Parameters{searchTerm: searchTerm, terms: []string{searchTerm},
+                         stockIndex: defaultStockIndex,
+                         section: defaultSection, year: defaultYear, page: page,
+                         from: "2019", to: "2021-06-30", metric: filingsMetric, breakdown: sectionBreakdown,
+                         fragments: 1, fragmentSize: 200}
+  req = httptest.NewRequest(http.MethodGet, "/search?searchterm=artificial+intelligence" +
+    "&from=2019&to=2021-06-30", nil)
+  handler(w, req)
+  if !reflect.DeepEqual(processedP, expectedP) {
+    t.Fatalf("processedP = %v, expectedP %v.", processedP, expectedP)
+  }
+
+  w = httptest.NewRecorder()
+  req = httptest.NewRequest(http.MethodGet, "/search?searchterm=cloud&from=2021&to=2019", nil)
+  handler(w, req)
+  res = w.Result()
+  defer res.Body.Close()
+  b, _ = io.ReadAll(res.Body)
+  body = strings.Join(strings.Fields(string(b)), " ")
+  expectedBody = "from must not be after to"
+  if res.StatusCode != http.StatusBadRequest || body != expectedBody {
+    t.Fatalf("status code %v, body %v, expected %v, %v.", res.StatusCode, body,
+      http.StatusBadRequest, expectedBody)
+  }
+
+  // test comparing terms, blank ones left out, and the table's tab
+  expectedP = Parameters{searchTerm: "supply chain",
+                         terms: []string{"inflation", "supply chain", "labor shortage"}, tab: 1,
+                         stockIndex: defaultStockIndex, section: defaultSection,
+                         year: defaultYear, page: page, metric: companiesMetric,
+                         breakdown: sectionBreakdown,
+                         fragments: 1, fragmentSize: 200}
+  req = httptest.NewRequest(http.MethodGet, "/search?searchterm=inflation&searchterm=+" +
+    "&searchterm=supply+chain&searchterm=labor+shortage&tab=1&metric=companies", nil)
+  handler(w, req)
+  if !reflect.DeepEqual(processedP, expectedP) {
+    t.Fatalf("processedP = %v, expectedP %v.", processedP, expectedP)
+  }
+
+  w = httptest.NewRecorder()
+  req = httptest.NewRequest(http.MethodGet, "/search?searchterm=inflation&searchterm=cloud&tab=2", nil)
+  handler(w, req)
+  res = w.Result()
+  defer res.Body.Close()
+  b, _ = io.ReadAll(res.Body)
+  body = strings.Join(strings.Fields(string(b)), " ")
+  expectedBody = "invalid tab parameter"
+  if res.StatusCode != http.StatusBadRequest || body != expectedBody {
+    t.Fatalf("status code %v, body %v, expected %v, %v.", res.StatusCode, body,
+      http.StatusBadRequest, expectedBody)
+  }
+
+  // test a sector breakdown filtered by sector, and invalid ones
+  expectedP = Parameters{searchTerm: "cloud", terms: []string{"cloud"},
+                         stockIndex: defaultStockIndex, section: defaultSection,
+                         year: defaultYear, page: page, metric: filingsMetric,
+                         sector: "Services", breakdown: sectorBreakdown,
+                         fragments: 1, fragmentSize: 200}
+  req = httptest.NewRequest(http.MethodGet, "/search?searchterm=cloud&sector=Services" +
+    "&breakdown=sector", nil)
+  handler(w, req)
+  if !reflect.DeepEqual(processedP, expectedP) {
+    t.Fatalf("processedP = %v, expectedP %v.", processedP, expectedP)
+  }
+
+  for reqStr, expectedBody := range map[string]string{
+    "/search?searchterm=cloud&sector=Software":                     "invalid sector parameter",
+    "/search?searchterm=cloud&breakdown=ticker":                    "invalid breakdown parameter",
+    "/search?searchterm=cloud&searchterm=ai&breakdown=sector":      "sector breakdown takes one search term",
+  } {
+    w = httptest.NewRecorder()
+    handler(w, httptest.NewRequest(http.MethodGet, reqStr, nil))
+    res = w.Result()
+    defer res.Body.Close()
+    b, _ = io.ReadAll(res.Body)
+    body = strings.Join(strings.Fields(string(b)), " ")
+    if res.StatusCode != http.StatusBadRequest || body != expectedBody {
+      t.Fatalf("%s status code %v, body %v, expected %v, %v.", reqStr, res.StatusCode, body,
+        http.StatusBadRequest, expectedBody)
+    }
+  }
+
+  // test a cursor is passed on and an invalid one
+  cursor := (&Cursor{After: []json.RawMessage{json.RawMessage(`"2012-02-28"`)}}).encode()
+  req = httptest.NewRequest(http.MethodGet, "/filter?searchterm=cloud&p=2&cursor=" + cursor, nil)
+  handler(w, req)
+  if processedP.cursor != cursor || processedP.page != 2 {
+    t.Fatalf("processedP = %v, expected cursor %s on page 2.", processedP, cursor)
+  }
+
+  w = httptest.NewRecorder()
+  req = httptest.NewRequest(http.MethodGet, "/filter?searchterm=cloud&p=2&cursor=x!", nil)
+  handler(w, req)
+  res = w.Result()
+  defer res.Body.Close()
+  b, _ = io.ReadAll(res.Body)
+  body = strings.Join(strings.Fields(string(b)), " ")
+  expectedBody = "invalid cursor parameter"
+  if res.StatusCode != http.StatusBadRequest || body != expectedBody {
+    t.Fatalf("status code %v, body %v, expected %v, %v.", res.StatusCode, body,
+      http.StatusBadRequest, expectedBody)
+  }
+}
+
+// test parseTickers function from server.go
+func TestParseTickers(t *testing.T) {
+  tickers, err := parseTickers(" aapl, MSFT;brk.b\nAAPL  bf-b ")
+  expected := []string{"AAPL", "MSFT", "BRK.B", "BF-B"}
+  if err != nil || !reflect.DeepEqual(tickers, expected) {
+    t.Fatalf("tickers %v, error %v, expected %v.", tickers, err, expected)
+  }
+  if tickers, err = parseTickers(""); err != nil || tickers != nil {
+    t.Fatalf("tickers %v, error %v, expected none.", tickers, err)
+  }
+  if _, err = parseTickers("AAPL,\"} OR 1=1"); err == nil {
+    t.Fatalf("expected an error for an invalid ticker.")
+  }
+  var many []string
+  for i := 0; i <= maxTickers; i++ {
+    many = append(many, "T" + strconv.Itoa(i))
+  }
+  if _, err = parseTickers(strings.Join(many, ",")); err == nil {
+    t.Fatalf("expected an error for more than %d tickers.", maxTickers)
+  }
+}
+
+// test processRange function from search.go
+func TestProcessRange(t *testing.T) {
+  cases := [][4]string {
+    // from, to, expected lower and upper
+    {"2019", "2021", "2018-12-31", "2022-01-01"},
+    {"2019-03-01", "2019-03-31", "2019-02-28", "2019-04-01"},
+    {"", "2006", strconv.Itoa(yearLowerBound-1) + "-12-31", "2007-01-01"},
+    {"2023-12-31", "", "2023-12-30", strconv.Itoa(yearUpperBound+1) + "-01-01"},
+  }
+  for _, c := range cases {
+    lower, upper, err := processRange(c[0], c[1])
+    if err != nil || lower != c[2] || upper != c[3] {
+      t.Fatalf(`processRange("%s", "%s") = %s, %s, %v, expected %s, %s.`,
+        c[0], c[1], lower, upper, err, c[2], c[3])
+    }
+  }
+
+  errorCases := [][3]string {
+    {"20x9", "2021", "invalid from parameter"},
+    {"2019", "2021-02-30", "invalid to parameter"},
+    {"2021-01-01", "2020", "from must not be after to"},
+  }
+  for _, c := range errorCases {
+    _, _, err := processRange(c[0], c[1])
+    if err == nil || err.Error() != c[2] {
+      t.Fatalf(`processRange("%s", "%s") error %v, expected %s.`, c[0], c[1], err, c[2])
+    }
+  }
+}
+
+// fake search backend so handlers can be tested without elasticsearch
+type fakeSearcher struct {
+  total int
+  hits  []Hit
+}
+
+func (f *fakeSearcher) histogramSearch(p *Parameters, searched []string) (
+  map[string](map[string]int), error) {
+  counts := make(map[string](map[string]int))
+  for _, section := range searched {
+    counts[section] = map[string]int{strconv.Itoa(yearUpperBound): f.total}
+  }
+  return counts, nil
+}
+
+// four filings with each section in the last year
+func (f *fakeSearcher) filingCounts(p *Parameters, searched []string) (
+  map[string](map[string]int), error) {
+  counts := make(map[string](map[string]int))
+  for _, section := range searched {
+    counts[section] = map[string]int{strconv.Itoa(yearUpperBound): 4*f.total}
+  }
+  return counts, nil
+}
+
+// matches in the Services sector in the last year, and four filings in it
+func (f *fakeSearcher) sectorCounts(p *Parameters, all bool) (
+  map[string](map[string]int), error) {
+  count := f.total
+  if all {
+    count = 4*f.total
+  }
+  return map[string](map[string]int){"Services": {strconv.Itoa(yearUpperBound): count}}, nil
+}
+
+// one company with every hit
+func (f *fakeSearcher) topCompanies(p *Parameters, size int) ([]CompanyCount, error) {
+  return []CompanyCount{{Ticker: "ABC", Name: "Abc Inc", Filings: f.total,
+    FirstYear: "2012", LastYear: strconv.Itoa(yearUpperBound)}}, nil
+}
+
+// ABC mentions it in the last year and XYZ from 2012
+func (f *fakeSearcher) companyTimelines(p *Parameters) ([]CompanyTimeline, error) {
+  last := strconv.Itoa(yearUpperBound)
+  var timelines []CompanyTimeline
+  timelines = addTimelineYear(timelines, "ABC", "Abc Inc", last, f.total)
+  timelines = addTimelineYear(timelines, "XYZ", "Xyz Corp", "2012", 1)
+  timelines = addTimelineYear(timelines, "XYZ", "Xyz Corp", last, 2)
+  sortTimelines(timelines)
+  return timelines, nil
+}
+
+// ABC's filings from 2012, a risk replaced each year
+func (f *fakeSearcher) filingSection(ticker, section string, year int) (*FilingSection, error) {
+  if ticker != "ABC" || year < 2012 {
+    return nil, nil
+  }
+  y := strconv.Itoa(year)
+  return &FilingSection{Id: y, Ticker: ticker, Name: "Abc Inc", Filed: y + "-02-28",
+    Url: "https://sec.gov/" + y, Section: section,
+    Text: "We face competition.\n\nRisks of " + y + ".\nWe rely on suppliers."}, nil
+}
+
+// filing 1 has both sections, the second matching the query
+func (f *fakeSearcher) filing(id string, q *queryNode) (*Filing, error) {
+  if id != "1" {
+    return nil, nil
+  }
+  risks := template.HTML("An <em>outage</em> of our <em>cloud</em> & more.")
+  if q == nil {
+    risks = "An outage of our cloud &amp; more."
+  }
+  return &Filing{Id: id, Ticker: "ABC", Name: "Abc Inc", Filed: "2012-02-28",
+    Url: "https://sec.gov/1", Sections: []FilingText{
+      newFilingText(sections[0], "We sell disks.", q),
+      newFilingText(sections[1], risks, q),
+    }}, nil
+}
+
+func (f *fakeSearcher) highlightSearch(p *Parameters, size int) (int, []Hit, error) {
+  return f.total, f.hits, nil
+}
+
+func (f *fakeSearcher) exportHits(p *Parameters, fn func(Hit) error) error {
+  for _, hit := range f.hits {
+    if err := fn(hit); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (f *fakeSearcher) facets() (*Facets, error) {
+  return newFacets("2001-03-30", "2025-02-14", []string{"S&P 500", "", "Dow Jones", "Nasdaq 100"},
+    []string{"Services", "", "Manufacturing"})
+}
+
+// test prepareTable function from server.go against the fake backend
+func TestPrepareTable(t *testing.T) {
+  searcher = &fakeSearcher{
+    total: 2*pageSz + 1,
+    hits:  []Hit{{Filed: "2012-02-28", Ticker: "ABC", Name: "Abc Inc", cursor: "next"}},
+  }
+  p := Parameters{searchTerm: "cloud", stockIndex: defaultStockIndex, section: sections[1],
+                  year: "2012", page: 2}
+
+  tableData, err := prepareTable(&p)
+  if err != nil {
+    t.Fatalf("prepareTable error: %s.", err)
+  }
+  if tableData.Page != 2 || tableData.Pages != 3 {
+    t.Fatalf("page %d of %d, expected page 2 of 3.", tableData.Page, tableData.Pages)
+  }
+  if len(tableData.Hits) != 1 || tableData.Hits[0].Ticker != "ABC" {
+    t.Fatalf("hits %v, expected the fake hit.", tableData.Hits)
+  }
+  if tableData.Next != "next" {
+    t.Fatalf("next %s, expected the last hit's cursor.", tableData.Next)
+  }
+  // sections dropdown also offers all sections
+  years := currentFacets().years()
+  if len(tableData.Years) != len(years)-1 || len(tableData.Sections) != len(sections) {
+    t.Fatalf("years %v, sections %v, expected selected values removed.",
+      tableData.Years, tableData.Sections)
+  }
+  for _, y := range tableData.Years {
+    if y == p.year {
+      t.Fatalf("years %v, expected %s removed.", tableData.Years, p.year)
+    }
+  }
+}
+
+// test updating the years, stock indices and sectors from the backend
+func TestUpdateFacets(t *testing.T) {
+  defer func(f Facets) { facets = f }(currentFacets())
+
+  updateFacets(&fakeSearcher{})
+  f := currentFacets()
+  expectedIndices := []string{"S&P 500", "Dow Jones", "Nasdaq 100"}
+  if strings.Join(f.StockIndices, ",") != strings.Join(expectedIndices, ",") {
+    t.Fatalf("stock indices %v, expected %v.", f.StockIndices, expectedIndices)
+  }
+  if strings.Join(f.Sectors, ",") != "Manufacturing,Services" {
+    t.Fatalf("sectors %v, expected Manufacturing and Services sorted.", f.Sectors)
+  }
+  years := f.years()
+  if len(years) != 25 || years[0] != "2001" || years[24] != "2025" {
+    t.Fatalf("years %v, expected 2001 to 2025.", years)
+  }
+  lower, upper := processYear("2001")
+  if lower != "2000-12-31" || upper != "2002-01-01" {
+    t.Fatalf(`processYear("2001") = %s, %s, expected the year to be in range.`, lower, upper)
+  }
+}
+
+// test the graph page ranks the top companies, linked to their hits
+func TestTopCompanies(t *testing.T) {
+  searcher = &fakeSearcher{total: 3}
+  w := httptest.NewRecorder()
+  processParameters(searchHandler)(w, httptest.NewRequest(http.MethodGet,
+    "/search?searchterm=cloud&stockindex=Dow+Jones", nil))
+  body := w.Body.String()
+  if w.Code != http.StatusOK || !strings.Contains(body, "Top companies mentioning cloud") {
+    t.Fatalf("status code %d, expected the top companies panel.", w.Code)
+  }
+
+  p := Parameters{terms: []string{"cloud", "ai"}, tab: 1, stockIndex: "Dow Jones",
+                  metric: filingsMetric}
+  link := companyURL(&p, CompanyCount{Ticker: "ABC", FirstYear: "2012", LastYear: "2019"})
+  expected := "/search?from=2012&metric=filings&searchterm=cloud&searchterm=ai" +
+    "&section=All+sections&stockindex=Dow+Jones&tab=1&tickers=ABC&to=2019"
+  if link != expected {
+    t.Fatalf("link %s, expected %s.", link, expected)
+  }
+  if !strings.Contains(body, "tickers=ABC&amp;to=" + strconv.Itoa(yearUpperBound) + `">ABC</a>`) {
+    t.Fatalf("body without the link to ABC's hits.")
+  }
 }
@@ -0,0 +1,514 @@
+package main
+
+import (
+  "fmt"
+  "html"
+  "regexp"
+  "strconv"
+  "strings"
+  "unicode"
+  "html/template"
+)
+
+/* search box query language:
+ *   cloud computing           bare words are one phrase, as before
+ *   "cloud computing" 'saas'  quoted phrases. a ' only quotes when it starts
+ *                             a word that doesn't follow bare words and a
+ *                             later ' ends a word, so the '90s and
+ *                             rock 'n' roll stay phrases. a quote doubled
+ *                             inside a phrase is the quote itself, as in
+ *                             'it''s "new"'
+ *   a AND b, a b              both, AND is implied between phrases and groups
+ *   a OR b                    either
+ *   a NOT b                   a without b
+ *   a NEAR/10 b               a within 10 words of b, in either order
+ *   ( ... )                   grouping
+ * operators must be upper case so "research and development" stays a phrase.
+ * precedence from loosest to tightest: OR, AND, NOT, NEAR */
+
+// largest n allowed in NEAR/n
+const maxNear = 100
+
+const (
+  phraseNode = iota
+  andNode
+  orNode
+  notNode
+  nearNode
+)
+
+type queryNode struct {
+  kind     int
+  text     string // phrase text for phraseNode
+  slop     int    // max words between phrases for nearNode
+  children []*queryNode
+}
+
+const (
+  wordToken = iota
+  quotedToken
+  lparenToken
+  rparenToken
+  andToken
+  orToken
+  notToken
+  nearToken
+)
+
+type queryToken struct {
+  kind int
+  text string
+  slop int // n of NEAR/n
+}
+
+func (t queryToken) String() string {
+  switch t.kind {
+  case lparenToken:
+    return "("
+  case rparenToken:
+    return ")"
+  case quotedToken:
+    return `"` + t.text + `"`
+  }
+  return t.text
+}
+
+// index of the quote closing the one at i, -1 if there is none. a ' only
+// closes at the end of a word, not in "company's", and doubled quotes are
+// part of the phrase
+func closingQuote(r []rune, i int) int {
+  c := r[i]
+  for end := i + 1; end < len(r); end++ {
+    if r[end] == c && end+1 < len(r) && r[end+1] == c {
+      end++
+      continue
+    }
+    if r[end] == c && (c == '"' || end+1 == len(r) || unicode.IsSpace(r[end+1]) || r[end+1] == ')') {
+      return end
+    }
+  }
+  return -1
+}
+
+func tokenizeQuery(s string) ([]queryToken, error) {
+  var tokens []queryToken
+  r := []rune(s)
+  for i := 0; i < len(r); {
+    c := r[i]
+    switch {
+    case unicode.IsSpace(c):
+      i++
+    case c == '(':
+      tokens = append(tokens, queryToken{kind: lparenToken})
+      i++
+    case c == ')':
+      tokens = append(tokens, queryToken{kind: rparenToken})
+      i++
+    case c == '"' || c == '\'' && (len(tokens) == 0 || tokens[len(tokens)-1].kind != wordToken) &&
+         closingQuote(r, i) >= 0:
+      end := closingQuote(r, i)
+      if end < 0 {
+        return nil, fmt.Errorf("missing closing %c quote", c)
+      }
+      text := strings.TrimSpace(strings.ReplaceAll(string(r[i+1:end]), string(c)+string(c), string(c)))
+      if text == "" {
+        return nil, fmt.Errorf("empty quoted phrase")
+      }
+      tokens = append(tokens, queryToken{kind: quotedToken, text: text})
+      i = end + 1
+    default:
+      // a ' not starting a quote is part of the word
+      end := i
+      for end < len(r) && !unicode.IsSpace(r[end]) && !strings.ContainsRune(`()"`, r[end]) {
+        end++
+      }
+      word := string(r[i:end])
+      switch word {
+      case "AND":
+        tokens = append(tokens, queryToken{kind: andToken, text: word})
+      case "OR":
+        tokens = append(tokens, queryToken{kind: orToken, text: word})
+      case "NOT":
+        tokens = append(tokens, queryToken{kind: notToken, text: word})
+      default:
+        if n, ok := strings.CutPrefix(word, "NEAR/"); ok {
+          slop, err := strconv.Atoi(n)
+          if err != nil || slop < 0 || slop > maxNear {
+            return nil, fmt.Errorf("%s needs a number of words from 0 to %d", word, maxNear)
+          }
+          tokens = append(tokens, queryToken{kind: nearToken, text: word, slop: slop})
+          break
+        }
+        tokens = append(tokens, queryToken{kind: wordToken, text: word})
+      }
+      i = end
+    }
+  }
+  return tokens, nil
+}
+
+type queryParser struct {
+  tokens []queryToken
+  pos    int
+}
+
+func (qp *queryParser) peek() (queryToken, bool) {
+  if qp.pos >= len(qp.tokens) {
+    return queryToken{}, false
+  }
+  return qp.tokens[qp.pos], true
+}
+
+// parseQuery parses a search box query, the error is meant to be shown to the user
+func parseQuery(s string) (*queryNode, error) {
+  tokens, err := tokenizeQuery(s)
+  if err != nil {
+    return nil, err
+  }
+  if len(tokens) == 0 {
+    return nil, fmt.Errorf("empty search term")
+  }
+  qp := &queryParser{tokens: tokens}
+  node, err := qp.parseOr()
+  if err != nil {
+    return nil, err
+  }
+  if t, ok := qp.peek(); ok {
+    if t.kind == rparenToken {
+      return nil, fmt.Errorf("unmatched )")
+    }
+    return nil, fmt.Errorf("unexpected %s", t)
+  }
+  if err = node.checkNegation(false); err != nil {
+    return nil, err
+  }
+  return node, nil
+}
+
+func (qp *queryParser) parseOr() (*queryNode, error) {
+  node, err := qp.parseAnd()
+  if err != nil {
+    return nil, err
+  }
+  children := []*queryNode{node}
+  for {
+    t, ok := qp.peek()
+    if !ok || t.kind != orToken {
+      break
+    }
+    qp.pos++
+    node, err = qp.parseAnd()
+    if err != nil {
+      return nil, err
+    }
+    children = append(children, node)
+  }
+  if len(children) == 1 {
+    return children[0], nil
+  }
+  return &queryNode{kind: orNode, children: children}, nil
+}
+
+func (qp *queryParser) parseAnd() (*queryNode, error) {
+  node, err := qp.parseUnary()
+  if err != nil {
+    return nil, err
+  }
+  children := []*queryNode{node}
+  for {
+    t, ok := qp.peek()
+    if !ok || t.kind == orToken || t.kind == rparenToken {
+      break
+    }
+    if t.kind == andToken {
+      qp.pos++
+    }
+    node, err = qp.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    children = append(children, node)
+  }
+  if len(children) == 1 {
+    return children[0], nil
+  }
+  return &queryNode{kind: andNode, children: children}, nil
+}
+
+func (qp *queryParser) parseUnary() (*queryNode, error) {
+  t, ok := qp.peek()
+  if ok && t.kind == notToken {
+    qp.pos++
+    node, err := qp.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    return &queryNode{kind: notNode, children: []*queryNode{node}}, nil
+  }
+  return qp.parseNear()
+}
+
+// a NEAR/n b NEAR/n c, only between phrases since neither backend can nest it
+func (qp *queryParser) parseNear() (*queryNode, error) {
+  node, err := qp.parsePrimary()
+  if err != nil {
+    return nil, err
+  }
+  t, ok := qp.peek()
+  if !ok || t.kind != nearToken {
+    return node, nil
+  }
+
+  near := &queryNode{kind: nearNode, slop: t.slop, children: []*queryNode{node}}
+  for ok && t.kind == nearToken {
+    if t.slop != near.slop {
+      return nil, fmt.Errorf("chained NEAR must use the same distance, NEAR/%d and %s",
+        near.slop, t)
+    }
+    qp.pos++
+    node, err = qp.parsePrimary()
+    if err != nil {
+      return nil, err
+    }
+    near.children = append(near.children, node)
+    t, ok = qp.peek()
+  }
+  for _, child := range near.children {
+    if child.kind != phraseNode {
+      return nil, fmt.Errorf("NEAR/%d needs a word or quoted phrase on each side", near.slop)
+    }
+  }
+  return near, nil
+}
+
+func (qp *queryParser) parsePrimary() (*queryNode, error) {
+  t, ok := qp.peek()
+  if !ok {
+    if qp.pos == 0 {
+      return nil, fmt.Errorf("empty search term")
+    }
+    return nil, fmt.Errorf("missing term after %s", qp.tokens[qp.pos-1])
+  }
+
+  switch t.kind {
+  case lparenToken:
+    qp.pos++
+    node, err := qp.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if t, ok = qp.peek(); !ok || t.kind != rparenToken {
+      return nil, fmt.Errorf("missing )")
+    }
+    qp.pos++
+    return node, nil
+  case quotedToken:
+    qp.pos++
+    return &queryNode{kind: phraseNode, text: t.text}, nil
+  case wordToken:
+    // consecutive bare words make up one phrase
+    var words []string
+    for ok && t.kind == wordToken {
+      words = append(words, t.text)
+      qp.pos++
+      t, ok = qp.peek()
+    }
+    return &queryNode{kind: phraseNode, text: strings.Join(words, " ")}, nil
+  }
+
+  if qp.pos == 0 {
+    return nil, fmt.Errorf("search term can't start with %s", t)
+  }
+  return nil, fmt.Errorf("missing term between %s and %s", qp.tokens[qp.pos-1], t)
+}
+
+// NOT only makes sense as a term to exclude from an AND, as in a NOT b.
+// neither backend can search for just the absence of a phrase
+func (n *queryNode) checkNegation(inAnd bool) error {
+  switch n.kind {
+  case notNode:
+    if !inAnd {
+      return fmt.Errorf("NOT must follow a term to exclude from, as in cloud NOT saas")
+    }
+    return n.children[0].checkNegation(false)
+  case andNode:
+    positive := false
+    for _, child := range n.children {
+      if child.kind != notNode {
+        positive = true
+      }
+      if err := child.checkNegation(true); err != nil {
+        return err
+      }
+    }
+    if !positive {
+      return fmt.Errorf("NOT must follow a term to exclude from, as in cloud NOT saas")
+    }
+  case orNode:
+    for _, child := range n.children {
+      if err := child.checkNegation(false); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// elasticsearch query matching the query in one field
+func (n *queryNode) esQuery(field string) Query {
+  switch n.kind {
+  case andNode:
+    b := &BoolQuery{}
+    for _, child := range n.children {
+      if child.kind == notNode {
+        b.MustNot = append(b.MustNot, child.children[0].esQuery(field))
+      } else {
+        b.Must = append(b.Must, child.esQuery(field))
+      }
+    }
+    return Query{Bool: b}
+  case orNode:
+    b := &BoolQuery{MinimumShouldMatch: 1}
+    for _, child := range n.children {
+      b.Should = append(b.Should, child.esQuery(field))
+    }
+    return Query{Bool: b}
+  case notNode:
+    // only reached for a lone NOT, which parseQuery rejects
+    return Query{Bool: &BoolQuery{MustNot: []Query{n.children[0].esQuery(field)}}}
+  case nearNode:
+    // intervals so the analyzer still applies, each phrase matched as a phrase
+    allOf := &IntervalsAllOf{MaxGaps: n.slop, Ordered: false}
+    for _, child := range n.children {
+      allOf.Intervals = append(allOf.Intervals, Intervals{Match: &IntervalsMatch{
+        Query: child.text, MaxGaps: 0, Ordered: true}})
+    }
+    return Query{Intervals: map[string]Intervals{field: {AllOf: allOf}}}
+  }
+  return Query{MatchPhrase: map[string]string{field: n.text}}
+}
+
+// FTS5 match expression for the query, without a column filter
+func (n *queryNode) ftsQuery() string {
+  switch n.kind {
+  case andNode:
+    var must, mustNot []string
+    for _, child := range n.children {
+      if child.kind == notNode {
+        mustNot = append(mustNot, child.children[0].ftsQuery())
+      } else {
+        must = append(must, child.ftsQuery())
+      }
+    }
+    // FTS5 NOT is binary, a NOT b
+    s := "(" + strings.Join(must, " AND ") + ")"
+    for _, m := range mustNot {
+      s = "(" + s + " NOT " + m + ")"
+    }
+    return s
+  case orNode:
+    var should []string
+    for _, child := range n.children {
+      should = append(should, child.ftsQuery())
+    }
+    return "(" + strings.Join(should, " OR ") + ")"
+  case notNode:
+    return "NOT " + n.children[0].ftsQuery()
+  case nearNode:
+    var phrases []string
+    for _, child := range n.children {
+      phrases = append(phrases, child.ftsQuery())
+    }
+    return fmt.Sprintf("NEAR(%s, %d)", strings.Join(phrases, " "), n.slop)
+  }
+  return `"` + strings.ReplaceAll(n.text, `"`, `""`) + `"`
+}
+
+// the query's NEAR nodes
+func (n *queryNode) nearNodes() []*queryNode {
+  var nears []*queryNode
+  if n.kind == nearNode {
+    nears = append(nears, n)
+  }
+  for _, child := range n.children {
+    nears = append(nears, child.nearNodes()...)
+  }
+  return nears
+}
+
+// lower case words of highlighted text, without punctuation
+func highlightWords(text string) []string {
+  return strings.FieldsFunc(strings.ToLower(html.UnescapeString(text)), func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+  })
+}
+
+// words the same but for an ending the stemmer may have changed, like
+// tariffs and tariff or companies and company
+func stemMatch(a, b string) bool {
+  ra, rb := []rune(a), []rune(b)
+  common := 0
+  for common < len(ra) && common < len(rb) && ra[common] == rb[common] {
+    common++
+  }
+  return a == b || common >= 4 && common >= min(len(ra), len(rb))-2
+}
+
+// whether each word of the highlighted text is a word of one of the NEAR's
+// phrases
+func (n *queryNode) nearPhrase(text string) bool {
+  for _, child := range n.children {
+    phrase := highlightWords(child.text)
+    match := true
+    for _, w := range highlightWords(text) {
+      found := false
+      for _, p := range phrase {
+        if stemMatch(w, p) {
+          found = true
+          break
+        }
+      }
+      if !found {
+        match = false
+        break
+      }
+    }
+    if match {
+      return true
+    }
+  }
+  return false
+}
+
+var highlighted = regexp.MustCompile(`<em>(.*?)</em>`)
+
+// both highlighters mark each phrase of a NEAR match separately, join
+// neighbouring highlights of the phrases of one NEAR at most its slop words
+// apart so the whole matched span is marked. other terms' highlights are
+// left apart even when close to a NEAR's
+func (n *queryNode) mergeNearHighlights(excerpt template.HTML) template.HTML {
+  nears := n.nearNodes()
+  if len(nears) == 0 {
+    return excerpt
+  }
+  s := string(excerpt)
+  spans := highlighted.FindAllStringSubmatchIndex(s, -1)
+  var b strings.Builder
+  pos := 0
+  for i := 1; i < len(spans); i++ {
+    prev, cur := spans[i-1], spans[i]
+    gap := s[prev[1]:cur[0]]
+    for _, near := range nears {
+      if len(strings.Fields(gap)) <= near.slop && near.nearPhrase(s[prev[2]:prev[3]]) &&
+         near.nearPhrase(s[cur[2]:cur[3]]) {
+        // drop the </em> and <em> around the gap
+        b.WriteString(s[pos:prev[3]])
+        b.WriteString(gap)
+        pos = cur[2]
+        break
+      }
+    }
+  }
+  b.WriteString(s[pos:])
+  return template.HTML(b.String())
+}
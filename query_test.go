@@ -0,0 +1,128 @@
+// unittests for the search box query parser
+package main
+
+import (
+  "testing"
+  "html/template"
+  "encoding/json"
+)
+
+// test parseQuery and the FTS5 compilation, which shows the parsed structure
+func TestParseQuery(t *testing.T) {
+  cases := [][2]string {
+    {`artificial intelligence`, `"artificial intelligence"`},
+    {`research and development`, `"research and development"`},
+    {`"cloud computing" 'saas'`, `("cloud computing" AND "saas")`},
+    {`cloud AND (outage OR breach) NOT "cloud computing"`,
+      `(("cloud" AND ("outage" OR "breach")) NOT "cloud computing")`},
+    {`a OR b c`, `("a" OR "b c")`},
+    {`a OR b AND c`, `("a" OR ("b" AND "c"))`},
+    {`(a OR b) (c)`, `(("a" OR "b") AND "c")`},
+    {`company's "cloud"`, `("company's" AND "cloud")`},
+    {`'he said "hi"'`, `"he said ""hi"""`},
+    {`the '90s`, `"the '90s"`},
+    {`rock 'n' roll`, `"rock 'n' roll"`},
+    {`'90s`, `"'90s"`},
+    {`tariff AND 'people's republic'`, `("tariff" AND "people's republic")`},
+    {`'it''s "new"'`, `"it's ""new"""`},
+    {`"say ""hi"" it's"`, `"say ""hi"" it's"`},
+    {`tariff NEAR/10 China`, `NEAR("tariff" "China", 10)`},
+    {`trade war NEAR/5 "supply chain" NEAR/5 china OR brexit`,
+      `(NEAR("trade war" "supply chain" "china", 5) OR "brexit")`},
+    {`risk NOT tariff NEAR/3 china`, `(("risk") NOT NEAR("tariff" "china", 3))`},
+  }
+  for _, c := range cases {
+    q, err := parseQuery(c[0])
+    if err != nil {
+      t.Fatalf("parseQuery(%s) error: %s.", c[0], err)
+    }
+    if fts := q.ftsQuery(); fts != c[1] {
+      t.Fatalf("parseQuery(%s).ftsQuery() = %s, expected %s.", c[0], fts, c[1])
+    }
+  }
+
+  // malformed queries, and the error shown to the user
+  errorCases := [][2]string {
+    {``, `empty search term`},
+    {`   `, `empty search term`},
+    {`(cloud`, `missing )`},
+    {`cloud)`, `unmatched )`},
+    {`cloud AND`, `missing term after AND`},
+    {`OR cloud`, `search term can't start with OR`},
+    {`cloud AND OR saas`, `missing term between AND and OR`},
+    {`"cloud`, `missing closing " quote`},
+    {`""`, `empty quoted phrase`},
+    {`NOT cloud`, `NOT must follow a term to exclude from, as in cloud NOT saas`},
+    {`cloud OR NOT saas`, `NOT must follow a term to exclude from, as in cloud NOT saas`},
+    {`()`, `missing term between ( and )`},
+    {`tariff NEAR/x china`, `NEAR/x needs a number of words from 0 to 100`},
+    {`tariff NEAR/101 china`, `NEAR/101 needs a number of words from 0 to 100`},
+    {`tariff NEAR/5`, `missing term after NEAR/5`},
+    {`a NEAR/5 b NEAR/6 c`, `chained NEAR must use the same distance, NEAR/5 and NEAR/6`},
+    {`tariff NEAR/5 (china OR japan)`, `NEAR/5 needs a word or quoted phrase on each side`},
+  }
+  for _, c := range errorCases {
+    _, err := parseQuery(c[0])
+    if err == nil || err.Error() != c[1] {
+      t.Fatalf("parseQuery(%s) error %v, expected %s.", c[0], err, c[1])
+    }
+  }
+}
+
+// test the elasticsearch bool query compiled from a parsed query
+func TestEsQuery(t *testing.T) {
+  q, err := parseQuery(`cloud AND (outage OR breach) NOT "cloud computing"`)
+  if err != nil {
+    t.Fatalf("parseQuery error: %s.", err)
+  }
+  b, err := json.Marshal(q.esQuery("1A. Risk Factors"))
+  if err != nil {
+    t.Fatalf("marshal error: %s.", err)
+  }
+  expected := `{"bool":{"must":[{"match_phrase":{"1A. Risk Factors":"cloud"}},` +
+    `{"bool":{"should":[{"match_phrase":{"1A. Risk Factors":"outage"}},` +
+    `{"match_phrase":{"1A. Risk Factors":"breach"}}],"minimum_should_match":1}}],` +
+    `"must_not":[{"match_phrase":{"1A. Risk Factors":"cloud computing"}}]}}`
+  if string(b) != expected {
+    t.Fatalf("esQuery = %s, expected %s.", b, expected)
+  }
+}
+
+// test intervals query for NEAR and merging of its highlights
+func TestNear(t *testing.T) {
+  q, err := parseQuery(`tariff NEAR/10 "people's republic"`)
+  if err != nil {
+    t.Fatalf("parseQuery error: %s.", err)
+  }
+  b, err := json.Marshal(q.esQuery("1A. Risk Factors"))
+  if err != nil {
+    t.Fatalf("marshal error: %s.", err)
+  }
+  expected := `{"intervals":{"1A. Risk Factors":{"all_of":{"intervals":[` +
+    `{"match":{"query":"tariff","max_gaps":0,"ordered":true}},` +
+    `{"match":{"query":"people's republic","max_gaps":0,"ordered":true}}],` +
+    `"max_gaps":10,"ordered":false}}}}`
+  if string(b) != expected {
+    t.Fatalf("esQuery = %s, expected %s.", b, expected)
+  }
+
+  excerpt := template.HTML(`new <em>tariffs</em> imposed by the <em>People's Republic</em> of ` +
+    `China, and a much later mention that is more than ten words away from <em>tariff</em>.`)
+  merged := q.mergeNearHighlights(excerpt)
+  expectedExcerpt := template.HTML(`new <em>tariffs imposed by the People's Republic</em> of ` +
+    `China, and a much later mention that is more than ten words away from <em>tariff</em>.`)
+  if merged != expectedExcerpt {
+    t.Fatalf("mergeNearHighlights = %s, expected %s.", merged, expectedExcerpt)
+  }
+  if q, _ = parseQuery("tariff china"); q.mergeNearHighlights(excerpt) != excerpt {
+    t.Fatalf("mergeNearHighlights changed highlights of a query without NEAR.")
+  }
+
+  // other terms next to a NEAR's phrases stay apart
+  q, _ = parseQuery("tariff NEAR/10 china OR brexit")
+  excerpt = template.HTML(`<em>Brexit</em> and new <em>tariffs</em> on <em>China</em>`)
+  expectedExcerpt = template.HTML(`<em>Brexit</em> and new <em>tariffs on China</em>`)
+  if merged = q.mergeNearHighlights(excerpt); merged != expectedExcerpt {
+    t.Fatalf("mergeNearHighlights = %s, expected %s.", merged, expectedExcerpt)
+  }
+}
@@ -5,22 +5,422 @@ import (
   "os"
   "io"
   "fmt"
-  "encoding/json"
+  "math"
+  "bytes"
   "strings"
+  "regexp"
+  "encoding/json"
+  "encoding/base64"
+  "time"
   "strconv"
+  "html"
   "html/template"
   "github.com/elastic/go-elasticsearch/v8"
+  "github.com/elastic/go-elasticsearch/v8/esapi"
+  "github.com/kyleleelarson/sec-search/config"
 )
 
-const indexName = "filings_2024_03_11"
+/* request bodies are built from these types and marshaled with encoding/json,
+ * so the user's search term and parameters are always escaped */
+type SearchRequest struct {
+  Source    []string               `json:"_source,omitempty"`
+  Query     Query                  `json:"query"`
+  Aggs      map[string]Aggregation `json:"aggs,omitempty"`
+  Highlight *Highlight             `json:"highlight,omitempty"`
+  Sort      []map[string]Sort      `json:"sort,omitempty"`
+  From      int                    `json:"from,omitempty"`
+  Size      int                    `json:"size"`
+  // score hits even though they are sorted by date
+  TrackScores bool                 `json:"track_scores,omitempty"`
+  // count every hit instead of up to 10,000
+  TrackTotalHits bool              `json:"track_total_hits,omitempty"`
+  // page through every hit in a point in time, see exportHits
+  PIT         *PIT                 `json:"pit,omitempty"`
+  SearchAfter []json.RawMessage    `json:"search_after,omitempty"`
+}
+
+type PIT struct {
+  Id        string `json:"id"`
+  KeepAlive string `json:"keep_alive"`
+}
+
+// only one of the fields is set in each query clause
+type Query struct {
+  Bool        *BoolQuery           `json:"bool,omitempty"`
+  MatchPhrase map[string]string    `json:"match_phrase,omitempty"`
+  Intervals   map[string]Intervals `json:"intervals,omitempty"`
+  Term        map[string]string    `json:"term,omitempty"`
+  Terms       map[string][]string  `json:"terms,omitempty"`
+  Range       map[string]Range     `json:"range,omitempty"`
+  Exists      *Exists              `json:"exists,omitempty"`
+  Ids         *Ids                 `json:"ids,omitempty"`
+}
+
+type BoolQuery struct {
+  Name               string  `json:"_name,omitempty"` // reported in matched_queries
+  Must               []Query `json:"must,omitempty"`
+  MustNot            []Query `json:"must_not,omitempty"`
+  Should             []Query `json:"should,omitempty"`
+  MinimumShouldMatch int     `json:"minimum_should_match,omitempty"`
+  Filter             []Query `json:"filter,omitempty"`
+}
+
+type Intervals struct {
+  Match *IntervalsMatch `json:"match,omitempty"`
+  AllOf *IntervalsAllOf `json:"all_of,omitempty"`
+}
+
+type IntervalsMatch struct {
+  Query   string `json:"query"`
+  MaxGaps int    `json:"max_gaps"`
+  Ordered bool   `json:"ordered"`
+}
+
+type IntervalsAllOf struct {
+  Intervals []Intervals `json:"intervals"`
+  MaxGaps   int         `json:"max_gaps"`
+  Ordered   bool        `json:"ordered"`
+}
+
+type Range struct {
+  Gt string `json:"gt,omitempty"`
+  Lt string `json:"lt,omitempty"`
+}
+
+type Exists struct {
+  Field string `json:"field"`
+}
+
+type Ids struct {
+  Values []string `json:"values"`
+}
+
+type Aggregation struct {
+  DateHistogram *DateHistogram `json:"date_histogram,omitempty"`
+  Min           *FieldAgg      `json:"min,omitempty"`
+  Max           *FieldAgg      `json:"max,omitempty"`
+  Terms         *TermsAgg      `json:"terms,omitempty"`
+  Cardinality   *FieldAgg      `json:"cardinality,omitempty"`
+  Composite     *Composite     `json:"composite,omitempty"`
+  Aggs          map[string]Aggregation `json:"aggs,omitempty"` // per bucket
+}
+
+type FieldAgg struct {
+  Field string `json:"field"`
+}
+
+type TermsAgg struct {
+  Field string `json:"field"`
+  Size  int    `json:"size"`
+}
+
+type DateHistogram struct {
+  Field            string `json:"field"`
+  CalendarInterval string `json:"calendar_interval"`
+  Format           string `json:"format,omitempty"` // of the bucket keys
+}
+
+// every bucket of the sources' combined values, a page at a time
+type Composite struct {
+  Size    int                          `json:"size"`
+  Sources []map[string]CompositeSource `json:"sources"`
+  After   map[string]any               `json:"after,omitempty"` // previous page's after_key
+}
+
+// only one of the fields is set in each source
+type CompositeSource struct {
+  Terms         *FieldAgg      `json:"terms,omitempty"`
+  DateHistogram *DateHistogram `json:"date_histogram,omitempty"`
+}
+
+type Highlight struct {
+  FragmentSize int                 `json:"fragment_size"`
+  // 0 highlights the whole field
+  NumberOfFragments *int           `json:"number_of_fragments,omitempty"`
+  Encoder      string              `json:"encoder"`
+  Fields       map[string]struct{} `json:"fields"`
+}
+
+type Sort struct {
+  Order        string `json:"order"`
+  UnmappedType string `json:"unmapped_type,omitempty"`
+}
+
+// elasticsearch field of a section, unknown sections simply match nothing
+func sectionField(name string) string {
+  if s, ok := config.FindSection(name); ok {
+    return s.Field
+  }
+  return name
+}
+
+// filings of the listed companies, or without a list those in the stock
+// index, and in the sector if one is picked
+func companyFilter(p *Parameters) []Query {
+  var filter []Query
+  if len(p.tickers) > 0 {
+    filter = append(filter, Query{Terms: map[string][]string{"Ticker.keyword": p.tickers}})
+  } else {
+    filter = append(filter, Query{Term: map[string]string{"StockIndex.keyword": p.stockIndex}})
+  }
+  if p.sector != "" {
+    filter = append(filter, Query{Term: map[string]string{"Sector.keyword": p.sector}})
+  }
+  return filter
+}
+
+// search term in one section or any of them, filtered by companies
+func filteredQuery(q *queryNode, section string, companies []Query) Query {
+  var must Query
+  if section == allSections {
+    // the whole query has to match within one section, named so each hit
+    // says which sections matched
+    b := &BoolQuery{MinimumShouldMatch: 1}
+    for _, s := range primarySections {
+      b.Should = append(b.Should,
+        Query{Bool: &BoolQuery{Name: s, Must: []Query{q.esQuery(sectionField(s))}}})
+    }
+    must = Query{Bool: b}
+  } else {
+    must = q.esQuery(sectionField(section))
+  }
+  return Query{Bool: &BoolQuery{
+    Must:   []Query{must},
+    Filter: append([]Query{}, companies...),
+  }}
+}
+
+// filings per year, and for the companies metric the tickers filing each year
+func yearAggregation(metric string) map[string]Aggregation {
+  year := Aggregation{DateHistogram: &DateHistogram{Field: "Filed", CalendarInterval: "1y"}}
+  if metric == companiesMetric {
+    year.Aggs = map[string]Aggregation{
+      "companies": {Cardinality: &FieldAgg{Field: "Ticker.keyword"}},
+    }
+  }
+  return map[string]Aggregation{"year": year}
+}
+
+func newHistogramRequest(q *queryNode, section string, companies []Query,
+  metric string) SearchRequest {
+
+  return SearchRequest{
+    Query: filteredQuery(q, section, companies),
+    Aggs:  yearAggregation(metric),
+    Size: 0,
+  }
+}
+
+// filings with the section. index_builder leaves out the sections a
+// filing doesn't have, indices built before it did have them as empty
+// strings, which exists matches but the dynamically mapped keyword
+// subfield indexes as ""
+func hasSectionQuery(section string) Query {
+  field := sectionField(section)
+  return Query{Bool: &BoolQuery{
+    MustNot: []Query{{Term: map[string]string{field + ".keyword": ""}}},
+    Filter:  []Query{{Exists: &Exists{Field: field}}},
+  }}
+}
+
+// every filing with the section, matching or not, counted by year.
+// every filing has some section
+func newFilingsRequest(section string, companies []Query, metric string) SearchRequest {
+  filter := append([]Query{}, companies...)
+  if section != allSections {
+    filter = append(filter, hasSectionQuery(section))
+  }
+  return SearchRequest{
+    Query: Query{Bool: &BoolQuery{Filter: filter}},
+    Aggs:  yearAggregation(metric),
+    Size: 0,
+  }
+}
+
+// filings matching in any section, or every filing for a nil query,
+// counted by sector and year
+func newSectorRequest(q *queryNode, companies []Query, metric string) SearchRequest {
+  query := Query{Bool: &BoolQuery{Filter: append([]Query{}, companies...)}}
+  if q != nil {
+    query = filteredQuery(q, allSections, companies)
+  }
+  return SearchRequest{
+    Query: query,
+    Aggs:  map[string]Aggregation{
+      "sectors": {
+        Terms: &TermsAgg{Field: "Sector.keyword", Size: len(config.Sectors)},
+        Aggs:  yearAggregation(metric),
+      },
+    },
+    Size: 0,
+  }
+}
+
+// filings matching in any section counted by company, the most first, with
+// the company's name and first and last matching filings
+func newTopCompaniesRequest(q *queryNode, companies []Query, size int) SearchRequest {
+  return SearchRequest{
+    Query: filteredQuery(q, allSections, companies),
+    Aggs:  map[string]Aggregation{
+      "companies": {
+        Terms: &TermsAgg{Field: "Ticker.keyword", Size: size},
+        Aggs:  map[string]Aggregation{
+          "name":  {Terms: &TermsAgg{Field: "Name.keyword", Size: 1}},
+          "first": {Min: &FieldAgg{Field: "Filed"}},
+          "last":  {Max: &FieldAgg{Field: "Filed"}},
+        },
+      },
+    },
+    Size: 0,
+  }
+}
+
+// filings matching the search term counted by company and year, a page of
+// timelineBatch buckets at a time ordered by ticker then year
+func newTimelineRequest(q *queryNode, section string, companies []Query) SearchRequest {
+  return SearchRequest{
+    Query: filteredQuery(q, section, companies),
+    Aggs:  map[string]Aggregation{
+      "timeline": {Composite: &Composite{
+        Size:    timelineBatch,
+        Sources: []map[string]CompositeSource{
+          {"ticker": {Terms: &FieldAgg{Field: "Ticker.keyword"}}},
+          {"name":   {Terms: &FieldAgg{Field: "Name.keyword"}}},
+          {"year":   {DateHistogram: &DateHistogram{Field: "Filed", CalendarInterval: "1y",
+                                                    Format: "yyyy"}}},
+        },
+      }},
+    },
+    Size: 0,
+  }
+}
+
+// a company's last filing with the section in the year, with its text
+func newFilingSectionRequest(ticker, section string, year int) SearchRequest {
+  return SearchRequest{
+    Source: []string{"Ticker", "Name", "Filed", "Url", sectionField(section)},
+    Query:  Query{Bool: &BoolQuery{Filter: []Query{
+      {Term: map[string]string{"Ticker.keyword": ticker}},
+      {Range: map[string]Range{"Filed": {Gt: strconv.Itoa(year-1) + "-12-31",
+                                         Lt: strconv.Itoa(year+1) + "-01-01"}}},
+      hasSectionQuery(section),
+    }}},
+    Sort: []map[string]Sort{{"Filed": {Order: "desc"}}},
+    Size: 1,
+  }
+}
+
+// a filing with the whole text of its sections, and every match of the
+// query highlighted unless it is nil
+func newFilingRequest(id string, q *queryNode) SearchRequest {
+  query := Query{Bool: &BoolQuery{Filter: []Query{{Ids: &Ids{Values: []string{id}}}}}}
+  source := []string{"Ticker", "Name", "Filed", "Url"}
+  for _, s := range config.Sections {
+    if !s.Derived {
+      source = append(source, s.Field)
+    }
+  }
+  req := SearchRequest{Source: source, Query: query, Size: 1}
+  if q == nil {
+    return req
+  }
+
+  // should clauses only highlight, the filter alone picks the filing
+  noFragments := 0
+  req.Highlight = &Highlight{Encoder: "html", NumberOfFragments: &noFragments,
+                             Fields: map[string]struct{}{}}
+  for _, s := range config.Sections {
+    if !s.Derived {
+      query.Bool.Should = append(query.Bool.Should, q.esQuery(s.Field))
+      req.Highlight.Fields[s.Field] = struct{}{}
+    }
+  }
+  return req
+}
+
+// the fragments a page of hits asks for, or the whole sections to count
+// every match in and cut the excerpts from
+func (h *Highlight) pageFragments(p *Parameters) {
+  if p.occurrences {
+    noFragments := 0
+    h.NumberOfFragments = &noFragments
+    return
+  }
+  fragments := p.fragments
+  h.NumberOfFragments, h.FragmentSize = &fragments, p.fragmentSize
+}
+
+// a page of hits, after the cursor's last hit or, for pages jumped to, from
+// the page number. from is limited by index.max_result_window. both pages
+// have the same sort, so they meet without gaps or repeats
+func newPageRequest(q *queryNode, p *Parameters, cursor *Cursor,
+  filedLower, filedUpper string, size int) SearchRequest {
+
+  req := newHighlightRequest(q, p.section, companyFilter(p), filedLower, filedUpper,
+    (p.page-1) * size, size)
+  req.Highlight.pageFragments(p)
+  if cursor != nil {
+    req.From = 0
+    req.SearchAfter = cursor.After
+  }
+  return req
+}
+
+func newHighlightRequest(q *queryNode, section string, companies []Query,
+  filedLower, filedUpper string, from, size int) SearchRequest {
+
+  query := filteredQuery(q, section, companies)
+  query.Bool.Filter = append(query.Bool.Filter,
+    Query{Range: map[string]Range{"Filed": {Gt: filedLower, Lt: filedUpper}}})
 
-var histogramQuery = `{ 
-  "size": 0,
-  "query": { "bool": { 
-    "must": [{ "match_phrase": { "%s": "%s" }}],
-    "filter": [{ "term": { "StockIndex.keyword": "%s" }}]}},
-  "aggs": { "year": { "date_histogram": { "field": "Filed", "calendar_interval": "1y"}}}
-}`
+  // html encoder escapes the filing text around the <em> tags
+  highlight := &Highlight{FragmentSize: 200, Encoder: "html", Fields: map[string]struct{}{}}
+  for _, s := range config.Sections {
+    highlight.Fields[s.Field] = struct{}{}
+  }
+
+  return SearchRequest{
+    Source:    []string{"Ticker", "Name", "StockIndex", "Filed", "Url"},
+    Query:     query,
+    Highlight: highlight,
+    // filings filed the same day are ordered by their unique url, so every
+    // page has the same order and search_after can resume between them
+    Sort:      []map[string]Sort{
+      {"Filed": {Order: "desc", UnmappedType: "date"}},
+      {"Url.keyword": {Order: "desc", UnmappedType: "keyword"}},
+    },
+    From:      from,
+    Size:      size,
+    TrackScores: true,
+    TrackTotalHits: true,
+  }
+}
+
+// the buckets of a yearAggregation
+type YearBuckets struct {
+  Buckets []struct {  
+    Date  string  `json:"key_as_string"`
+    Count float64 `json:"doc_count"`
+    Companies struct {
+      Value float64 `json:"value"`
+    } `json:"companies"` // companies metric only
+  } `json:"buckets"`
+}
+
+// filings or companies per year
+func (y *YearBuckets) counts(metric string) map[string]int {
+  m := make(map[string]int)
+  for _, b := range y.Buckets {
+    year := b.Date[:4]
+    count := int(b.Count)
+    if metric == companiesMetric {
+      // cardinality is approximate above 3000 companies
+      count = int(b.Companies.Value)
+    }
+    m[year] = count
+  }
+  return m
+}
 
 type HistogramResult struct {
   Took float64 `json:"took"`
@@ -30,29 +430,73 @@ type HistogramResult struct {
     } `json:"total"`
   }
   Aggregations struct {
-    Year struct {
-      Buckets []struct {  
-        Date  string  `json:"key_as_string"`
+    Year YearBuckets `json:"year"`
+  } `json:"aggregations"`
+}
+
+type SectorResult struct {
+  Aggregations struct {
+    Sectors struct {
+      Buckets []struct {
+        Key  string      `json:"key"`
+        Year YearBuckets `json:"year"`
+      } `json:"buckets"`
+    } `json:"sectors"`
+  } `json:"aggregations"`
+}
+
+type TopCompaniesResult struct {
+  Aggregations struct {
+    Companies struct {
+      Buckets []struct {
+        Key   string  `json:"key"`
+        Count float64 `json:"doc_count"`
+        Name struct {
+          Buckets []struct {
+            Key string `json:"key"`
+          } `json:"buckets"`
+        } `json:"name"`
+        First struct {
+          Date string `json:"value_as_string"`
+        } `json:"first"`
+        Last struct {
+          Date string `json:"value_as_string"`
+        } `json:"last"`
+      } `json:"buckets"`
+    } `json:"companies"`
+  } `json:"aggregations"`
+}
+
+type TimelineResult struct {
+  Aggregations struct {
+    Timeline struct {
+      AfterKey map[string]any `json:"after_key"` // missing after the last page
+      Buckets  []struct {
+        Key struct {
+          Ticker string `json:"ticker"`
+          Name   string `json:"name"`
+          Year   string `json:"year"`
+        } `json:"key"`
         Count float64 `json:"doc_count"`
       } `json:"buckets"`
-    } `json:"year"`
+    } `json:"timeline"`
   } `json:"aggregations"`
 }
 
-var highlightQuery = `{ 
-  "_source": ["Ticker", "Name", "StockIndex", "Filed", "Url"],
-  "query": { "bool": { 
-    "must": [{ "match_phrase": { "%s": "%s" }}],
-    "filter": [{ "term": { "StockIndex.keyword": "%s" }},
-               { "range": { "Filed": { "gt": "%s", "lt": "%s"}}}]}},
-  "highlight": { "fragment_size": 200, "fields": { "1. Business": {}, "1A. Risk Factors": {} } },
-  "sort": [ { "Filed": { "order": "desc", "unmapped_type": "date" } } ],
-  "from": %d,
-  "size": %d
-}`
+// hits with their _source fields, and highlights when asked for
+type SourceResult struct {
+  Hits struct {
+    Values []struct {
+      Id         string                        `json:"_id"`
+      Source     map[string]string             `json:"_source"`
+      Highlights map[string]([]template.HTML)  `json:"highlight"`
+    } `json:"hits"`
+  } `json:"hits"`
+}
 
 type HighlightResult struct {
-  Took float64 `json:"took"`
+  Took  float64 `json:"took"`
+  PitId string  `json:"pit_id"` // may change between searches in a point in time
   Hits struct {
     Total struct {
       Num int `json:"value"`
@@ -67,15 +511,180 @@ type HighlightResult struct {
         Filed      string
         Url        string
       } `json:"_source"`
-      Highlights struct {
-        // use template.HTML so <em> is not escaped
-        Item1  []template.HTML `json:"1. Business"`
-        Item1a []template.HTML `json:"1A. Risk Factors"`
-      } `json:"highlight"`
+      Sort           []json.RawMessage `json:"sort"` // search_after for the next batch
+      MatchedQueries []string `json:"matched_queries"`
+      // fragments per section, use template.HTML so <em> is not escaped
+      Highlights map[string]([]template.HTML) `json:"highlight"`
     } `json:"hits"`
   } `json:"hits"`
 }
 
+var facetsRequest = SearchRequest{
+  Aggs: map[string]Aggregation{
+    "first": {Min: &FieldAgg{Field: "Filed"}},
+    "last":  {Max: &FieldAgg{Field: "Filed"}},
+    "stock_indices": {Terms: &TermsAgg{Field: "StockIndex.keyword", Size: 100}},
+    "sectors": {Terms: &TermsAgg{Field: "Sector.keyword", Size: len(config.Sectors)}},
+  },
+  Size: 0,
+}
+
+type FacetsResult struct {
+  Aggregations struct {
+    First struct {
+      Date string `json:"value_as_string"`
+    } `json:"first"`
+    Last struct {
+      Date string `json:"value_as_string"`
+    } `json:"last"`
+    StockIndices struct {
+      Buckets []struct {
+        Key string `json:"key"`
+      } `json:"buckets"`
+    } `json:"stock_indices"`
+    Sectors struct {
+      Buckets []struct {
+        Key string `json:"key"`
+      } `json:"buckets"`
+    } `json:"sectors"`
+  } `json:"aggregations"`
+}
+
+// one filing matching a search, shown as a table row and returned by the API
+type Hit struct {
+  Id      string        `json:"id"` // accession number
+  Ticker  string        `json:"ticker"`
+  Name    string        `json:"name"`
+  Filed   string        `json:"filed"`
+  Url     string        `json:"url"`
+  Section string        `json:"section"`
+  Excerpt template.HTML `json:"excerpt"` // the first of the excerpts
+  Excerpts []template.HTML `json:"excerpts"`
+  // matches in the section searched, or every section for all sections,
+  // NEAR matches once per span. 0, and left out of the JSON, when not
+  // counted
+  SectionOccurrences int  `json:"section_occurrences,omitempty"`
+  Score   float64         `json:"score"`
+  cursor  string        // next page after this hit, only set on a page's last hit
+}
+
+// a filing with one section's text
+type FilingSection struct {
+  Id      string // accession number
+  Ticker  string
+  Name    string
+  Filed   string
+  Url     string
+  Section string
+  Text    string
+}
+
+// a filing's sections, the ones it has in order, with every match of a
+// search term highlighted
+type Filing struct {
+  Id       string // accession number
+  Ticker   string
+  Name     string
+  Filed    string
+  Url      string
+  Sections []FilingText
+}
+
+type FilingText struct {
+  Section string
+  Text    template.HTML // escaped, with matches in <em> tags
+  Matches int
+}
+
+// a section's text with its matches counted, NEAR matches once per span
+func newFilingText(section string, text template.HTML, q *queryNode) FilingText {
+  if q != nil {
+    text = q.mergeNearHighlights(text)
+  }
+  return FilingText{Section: section, Text: text, Matches: strings.Count(string(text), "<em>")}
+}
+
+// a company mentioning a search term, ranked by its matching filings
+type CompanyCount struct {
+  Ticker    string `json:"ticker"`
+  Name      string `json:"name"`
+  Filings   int    `json:"filings"`
+  FirstYear string `json:"first_year"` // of the first and last matching filings
+  LastYear  string `json:"last_year"`
+}
+
+// where the next page of hits starts, carried in the cursor parameter.
+// elasticsearch resumes after the sort values of the last hit, its filed
+// date and url, sqlite after its filed date and accession number
+type Cursor struct {
+  After []json.RawMessage `json:"after"`
+}
+
+func (c *Cursor) encode() string {
+  b, err := json.Marshal(c)
+  if err != nil {
+    // only RawMessage from json can be in a cursor
+    log.Printf("error encoding cursor: %s\n", err)
+    return ""
+  }
+  return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// the cursor parameter, nil without one
+func decodeCursor(s string) (*Cursor, error) {
+  if s == "" {
+    return nil, nil
+  }
+  b, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil {
+    return nil, err
+  }
+  var c Cursor
+  if err = json.Unmarshal(b, &c); err != nil {
+    return nil, err
+  }
+  if len(c.After) == 0 {
+    return nil, fmt.Errorf("cursor without search after values")
+  }
+  return &c, nil
+}
+
+// Searcher is implemented by each search backend the server can query.
+// histogramSearch returns counts of matching filings, or companies for the
+// companies metric, per year for each of the sections searched, which may
+// include allSections,
+// filingCounts returns counts of all filings or companies per year for each
+// section, to normalize histogramSearch's,
+// sectorCounts returns counts of filings matching in any section, or all
+// filings, per sector per year,
+// topCompanies returns up to size companies with the most filings matching
+// in any section, the most first,
+// companyTimelines returns the years each company filed matching in the
+// section, see CompanyTimeline,
+// filingSection returns a company's last filing with the section in the
+// year, nil without one,
+// filing returns a filing's sections highlighting the query's matches, or
+// just its sections for a nil query, nil for an unknown accession number,
+// highlightSearch returns the total hit count and a page of highlighted hits,
+// exportHits calls fn with every hit, newest first, until fn returns an error,
+// facets returns the filing years and stock indices in the index.
+type Searcher interface {
+  histogramSearch(p *Parameters, searched []string) (map[string](map[string]int), error)
+  filingCounts(p *Parameters, searched []string) (map[string](map[string]int), error)
+  sectorCounts(p *Parameters, all bool) (map[string](map[string]int), error)
+  topCompanies(p *Parameters, size int) ([]CompanyCount, error)
+  companyTimelines(p *Parameters) ([]CompanyTimeline, error)
+  filingSection(ticker, section string, year int) (*FilingSection, error)
+  filing(id string, q *queryNode) (*Filing, error)
+  highlightSearch(p *Parameters, size int) (int, []Hit, error)
+  exportHits(p *Parameters, fn func(Hit) error) error
+  facets() (*Facets, error)
+}
+
+const exportBatch = 1000       // hits per search when exporting
+const exportKeepAlive = "1m"   // between export searches
+const timelineBatch = 1000     // company years per timeline search
+
 type ElasticClient struct {
   es *elasticsearch.Client
 }
@@ -101,106 +710,510 @@ func NewElasticClient() *ElasticClient {
   return &ElasticClient{es: es}
 }
 
-func (client *ElasticClient) histogramSearch(searchTerm, stockIndex string) (
+// run a search request and unmarshal the response body into result
+func (client *ElasticClient) search(req SearchRequest, result any) error {
+  reqBody, err := json.Marshal(req)
+  if err != nil {
+    return err
+  }
+  opts := []func(*esapi.SearchRequest){client.es.Search.WithBody(bytes.NewReader(reqBody))}
+  if req.PIT == nil {
+    // a point in time already names the index
+    opts = append(opts, client.es.Search.WithIndex(config.IndexName))
+  }
+  res, err := client.es.Search(opts...)
+  if err != nil {
+    return err
+  }
+  defer res.Body.Close()
+  if res.IsError() || res.Status() != "200 OK" {
+    return fmt.Errorf("status not 200 OK or res.IsError: %s", res.String())
+  }
+
+  body, err := io.ReadAll(res.Body)
+  if err != nil {
+    return err
+  }
+  return json.Unmarshal(body, result)
+}
+
+func (client *ElasticClient) histogramSearch(p *Parameters, searched []string) (
   map[string](map[string]int), error) {
 
-  var (
-    err error
-    histogramResult HistogramResult
-  )
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return make(map[string](map[string]int)), err
+  }
+  return client.yearCounts(searched, p.metric, func(section string) SearchRequest {
+    return newHistogramRequest(q, section, companyFilter(p), p.metric)
+  })
+}
+
+func (client *ElasticClient) filingCounts(p *Parameters, searched []string) (
+  map[string](map[string]int), error) {
+
+  return client.yearCounts(searched, p.metric, func(section string) SearchRequest {
+    return newFilingsRequest(section, companyFilter(p), p.metric)
+  })
+}
+
+// counts per section per year from each section's date histogram request
+func (client *ElasticClient) yearCounts(searched []string, metric string,
+  newRequest func(section string) SearchRequest) (map[string](map[string]int), error) {
+
   counts := make(map[string](map[string]int))
 
-  for _, section := range sections {
-    m := make(map[string]int)
-    res, err := client.es.Search(
-      client.es.Search.WithIndex(indexName),
-      client.es.Search.WithBody(strings.NewReader(
-        fmt.Sprintf(histogramQuery, section, searchTerm, stockIndex))),
-    )
+  for _, section := range searched {
+    var histogramResult HistogramResult
+    err := client.search(newRequest(section), &histogramResult)
     if err != nil {
       return counts, err
     }
-    defer res.Body.Close()
-    if res.IsError() || res.Status() != "200 OK" {
-      err = fmt.Errorf("status not 200 OK or res.IsError: %s", res.String())
+    counts[section] = histogramResult.Aggregations.Year.counts(metric)
+  }
+  return counts, nil
+}
+
+func (client *ElasticClient) sectorCounts(p *Parameters, all bool) (
+  map[string](map[string]int), error) {
+
+  counts := make(map[string](map[string]int))
+
+  var q *queryNode
+  if !all {
+    var err error
+    if q, err = parseQuery(p.searchTerm); err != nil {
       return counts, err
     }
+  }
+  var sectorResult SectorResult
+  if err := client.search(newSectorRequest(q, companyFilter(p), p.metric), &sectorResult); err != nil {
+    return counts, err
+  }
+  for _, b := range sectorResult.Aggregations.Sectors.Buckets {
+    counts[b.Key] = b.Year.counts(p.metric)
+  }
+  return counts, nil
+}
 
-    body, err := io.ReadAll(res.Body)
-    if err != nil {
-      return counts, err
+func (client *ElasticClient) topCompanies(p *Parameters, size int) ([]CompanyCount, error) {
+  var companies []CompanyCount
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return companies, err
+  }
+  var topResult TopCompaniesResult
+  if err = client.search(newTopCompaniesRequest(q, companyFilter(p), size), &topResult); err != nil {
+    return companies, err
+  }
+  for _, b := range topResult.Aggregations.Companies.Buckets {
+    c := CompanyCount{Ticker: b.Key, Filings: int(b.Count)}
+    if len(b.Name.Buckets) > 0 {
+      c.Name = b.Name.Buckets[0].Key
     }
-    if err = json.Unmarshal(body, &histogramResult); err != nil {
-      return counts, err
+    if len(b.First.Date) >= 4 && len(b.Last.Date) >= 4 {
+      c.FirstYear, c.LastYear = b.First.Date[:4], b.Last.Date[:4]
+    }
+    companies = append(companies, c)
+  }
+  return companies, nil
+}
+
+func (client *ElasticClient) companyTimelines(p *Parameters) ([]CompanyTimeline, error) {
+  var timelines []CompanyTimeline
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return timelines, err
+  }
+  req := newTimelineRequest(q, p.section, companyFilter(p))
+  for {
+    var timelineResult TimelineResult
+    if err = client.search(req, &timelineResult); err != nil {
+      return timelines, err
+    }
+    timeline := timelineResult.Aggregations.Timeline
+    for _, b := range timeline.Buckets {
+      timelines = addTimelineYear(timelines, b.Key.Ticker, b.Key.Name, b.Key.Year, int(b.Count))
+    }
+    if len(timeline.Buckets) < timelineBatch || timeline.AfterKey == nil {
+      break
+    }
+    req.Aggs["timeline"].Composite.After = timeline.AfterKey
+  }
+  sortTimelines(timelines)
+  return timelines, nil
+}
+
+func (client *ElasticClient) filingSection(ticker, section string, year int) (
+  *FilingSection, error) {
+
+  var filingResult SourceResult
+  if err := client.search(newFilingSectionRequest(ticker, section, year), &filingResult); err != nil {
+    return nil, err
+  }
+  if len(filingResult.Hits.Values) == 0 {
+    return nil, nil
+  }
+  hit := filingResult.Hits.Values[0]
+  return &FilingSection{Id: hit.Id, Ticker: hit.Source["Ticker"], Name: hit.Source["Name"],
+    Filed: hit.Source["Filed"], Url: hit.Source["Url"], Section: section,
+    Text: hit.Source[sectionField(section)]}, nil
+}
+
+func (client *ElasticClient) filing(id string, q *queryNode) (*Filing, error) {
+  var filingResult SourceResult
+  if err := client.search(newFilingRequest(id, q), &filingResult); err != nil {
+    return nil, err
+  }
+  if len(filingResult.Hits.Values) == 0 {
+    return nil, nil
+  }
+  hit := filingResult.Hits.Values[0]
+  f := Filing{Id: hit.Id, Ticker: hit.Source["Ticker"], Name: hit.Source["Name"],
+    Filed: hit.Source["Filed"], Url: hit.Source["Url"]}
+  for _, s := range config.Sections {
+    text, ok := hit.Source[s.Field]
+    if !ok {
+      continue
+    }
+    // the highlighter leaves out sections without matches
+    highlighted := template.HTML(html.EscapeString(text))
+    if fragments := hit.Highlights[s.Field]; len(fragments) > 0 {
+      highlighted = fragments[0]
+    }
+    f.Sections = append(f.Sections, newFilingText(s.Name, highlighted, q))
+  }
+  return &f, nil
+}
+
+// percent of all filings matching per section per year, zero for years
+// without filings
+func shareOfFilings(counts, filings map[string](map[string]int)) map[string](map[string]float64) {
+  shares := make(map[string](map[string]float64))
+  for section, years := range counts {
+    m := make(map[string]float64)
+    for year, count := range years {
+      if total := filings[section][year]; total > 0 {
+        m[year] = math.Round(10000 * float64(count) / float64(total)) / 100
+      }
+    }
+    shares[section] = m
+  }
+  return shares
+}
+
+// the excerpt with the most highlighted words and the section it came from,
+// ties go to the earlier section
+func bestExcerpt(excerpts map[string]template.HTML) (string, template.HTML) {
+  var (
+    best string
+    most = -1
+  )
+  for _, s := range sections {
+    excerpt, ok := excerpts[s]
+    if !ok {
+      continue
+    }
+    if n := strings.Count(string(excerpt), "<em>"); n > most {
+      best, most = s, n
     }
+  }
+  return best, excerpts[best]
+}
+
+var highlightSpan = regexp.MustCompile(`<em>.*?</em>`)
 
-    for _, b := range histogramResult.Aggregations.Year.Buckets {
-      year := b.Date[:4]
-      count := int(b.Count)
-      m[year] = count
+// cut a hit's whole highlighted section into up to n excerpts of about size
+// characters, in order, each around the first match the one before it left
+// out. a section without matches gives its start
+func (hit *Hit) cutExcerpts(n, size int) {
+  text := string(hit.Excerpt)
+  spans := highlightSpan.FindAllStringIndex(text, -1)
+  if len(spans) == 0 {
+    spans = [][]int{{0, 0}}
+  }
+
+  var excerpts []template.HTML
+  end := 0
+  for i, span := range spans {
+    if len(excerpts) == n {
+      break
+    }
+    if span[0] < end {
+      continue
+    }
+    // centered on the match, from the start of a word. a match longer
+    // than size, like a merged NEAR span, makes the excerpt longer
+    start := min(span[0], max(end, span[0] - (size - (span[1] - span[0]))/2))
+    if start > 0 {
+      if j := strings.IndexAny(text[start:span[0]], " \t\n"); j >= 0 {
+        start += j + 1
+      } else {
+        start = span[0]
+      }
+    }
+    // to the end of a word, so never inside a character or an entity,
+    // or of a match it would cut
+    stop := min(len(text), max(start + size, span[1]))
+    if stop < len(text) {
+      if j := strings.LastIndexAny(text[span[1]:stop], " \t\n"); j >= 0 {
+        stop = span[1] + j
+      } else if j := strings.IndexAny(text[stop:], " \t\n"); j >= 0 {
+        stop += j
+      } else {
+        stop = len(text)
+      }
     }
-  counts[section] = m
+    for _, later := range spans[i+1:] {
+      if later[0] < stop && stop < later[1] {
+        stop = later[1]
+      }
+    }
+    end = stop
+    excerpts = append(excerpts, template.HTML(strings.TrimSpace(text[start:stop])))
   }
-  return counts, err
+  hit.Excerpt, hit.Excerpts = excerpts[0], excerpts
+}
+
+// highlighted matches in all the excerpts
+func occurrences(excerpts map[string]template.HTML) int {
+  n := 0
+  for _, excerpt := range excerpts {
+    n += strings.Count(string(excerpt), "<em>")
+  }
+  return n
 }
 
 func processYear(year string) (string, string) {
+  f := currentFacets()
   i, err := strconv.Atoi(year)
-  if err != nil || i < yearLowerBound || i > yearUpperBound {
-    return strconv.Itoa(yearLowerBound) + "-12-31", strconv.Itoa(yearUpperBound) + "-01-01"
+  if err != nil || i < f.FirstYear || i > f.LastYear {
+    return strconv.Itoa(f.FirstYear) + "-12-31", strconv.Itoa(f.LastYear) + "-01-01"
   }
   return strconv.Itoa(i-1) + "-12-31", strconv.Itoa(i+1) + "-01-01"
 }
 
-func (client *ElasticClient) highlightSearch(searchTerm, stockIndex, section, year string, 
-  page, size int) (int, [](map[string]any), error) {
+// parse a from or to parameter, a year or a full date. a year starts on
+// January 1 for from and ends on December 31 for to
+func parseFiled(s string, isTo bool) (time.Time, error) {
+  if len(s) == 4 {
+    year, err := strconv.Atoi(s)
+    if err != nil {
+      return time.Time{}, err
+    }
+    if isTo {
+      return time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC), nil
+    }
+    return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), nil
+  }
+  return time.Parse(time.DateOnly, s)
+}
+
+// exclusive Filed bounds for an inclusive from, to range like processYear returns.
+// either end may be empty for the first or last year
+func processRange(from, to string) (string, string, error) {
+  if from == "" {
+    from = strconv.Itoa(currentFacets().FirstYear)
+  }
+  if to == "" {
+    to = strconv.Itoa(currentFacets().LastYear)
+  }
+  fromDate, err := parseFiled(from, false)
+  if err != nil {
+    return "", "", fmt.Errorf("invalid from parameter")
+  }
+  toDate, err := parseFiled(to, true)
+  if err != nil {
+    return "", "", fmt.Errorf("invalid to parameter")
+  }
+  if fromDate.After(toDate) {
+    return "", "", fmt.Errorf("from must not be after to")
+  }
+  return fromDate.AddDate(0, 0, -1).Format(time.DateOnly),
+    toDate.AddDate(0, 0, 1).Format(time.DateOnly), nil
+}
+
+func (client *ElasticClient) highlightSearch(p *Parameters, size int) (
+  int, []Hit, error) {
 
   var (
     total = 0
-    hits [](map[string]any)
-    err error
+    hits []Hit
     highlightResult HighlightResult
   )
 
-  yearLower, yearUpper := processYear(year)
-
-  res, err := client.es.Search(
-    client.es.Search.WithIndex(indexName),
-    client.es.Search.WithBody(strings.NewReader(
-      fmt.Sprintf(highlightQuery, section, searchTerm, stockIndex, yearLower, yearUpper, 
-      (page-1) * size, size))),
-  )
+  filedLower, filedUpper, err := p.filedRange()
   if err != nil {
     return total, hits, err
   }
-  defer res.Body.Close()
-  if res.IsError() || res.Status() != "200 OK" {
-    err = fmt.Errorf("status not 200 OK or res.IsError: %s", res.String())
+
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
     return total, hits, err
   }
 
-  body, err := io.ReadAll(res.Body)
+  cursor, err := decodeCursor(p.cursor)
   if err != nil {
     return total, hits, err
   }
-  if err = json.Unmarshal(body, &highlightResult); err != nil {
+
+  req := newPageRequest(q, p, cursor, filedLower, filedUpper, size)
+  if err = client.search(req, &highlightResult); err != nil {
     return total, hits, err
   }
 
   total = highlightResult.Hits.Total.Num
-
-  for _, hit := range highlightResult.Hits.Values {
-    m := make(map[string]any)
-    m["Filed"] = hit.Source.Filed
-    m["Ticker"] = hit.Source.Ticker
-    m["Name"] = hit.Source.Name
-    m["Url"] = hit.Source.Url
-    if section == "1. Business" {
-      m["Excerpt"] = hit.Highlights.Item1[0]
+  hits = highlightResult.hits(q, p.section)
+  for i := range hits {
+    if p.occurrences {
+      hits[i].cutExcerpts(p.fragments, p.fragmentSize)
     } else {
-      m["Excerpt"] = hit.Highlights.Item1a[0]
+      // fragments only have some of the matches
+      hits[i].SectionOccurrences = 0
     }
-    hits = append(hits, m)
   }
   return total, hits, err
 }
+
+// table rows for the hits in a result, with the best excerpt of the
+// section searched or, for all sections, of the sections that matched,
+// and the matches in their highlights. the last one has the cursor to the
+// hits after them
+func (result *HighlightResult) hits(q *queryNode, section string) []Hit {
+  var hits []Hit
+  for i, hit := range result.Hits.Values {
+    // each section's fragments, and joined to pick the best section by
+    fragments := make(map[string][]template.HTML)
+    excerpts := make(map[string]template.HTML)
+    candidates := []string{section}
+    if section == allSections {
+      candidates = hit.MatchedQueries
+    }
+    for _, s := range candidates {
+      var joined []string
+      for _, fragment := range hit.Highlights[sectionField(s)] {
+        fragment = q.mergeNearHighlights(fragment)
+        fragments[s] = append(fragments[s], fragment)
+        joined = append(joined, string(fragment))
+      }
+      if len(joined) > 0 {
+        excerpts[s] = template.HTML(strings.Join(joined, " "))
+      }
+    }
+    hitSection, _ := bestExcerpt(excerpts)
+    var excerpt template.HTML
+    if len(fragments[hitSection]) > 0 {
+      excerpt = fragments[hitSection][0]
+    }
+    hits = append(hits, Hit{
+      Id:      hit.Id,
+      Ticker:  hit.Source.Ticker,
+      Name:    hit.Source.Name,
+      Filed:   hit.Source.Filed,
+      Url:     hit.Source.Url,
+      Section: hitSection,
+      Excerpt: excerpt,
+      Excerpts: fragments[hitSection],
+      SectionOccurrences: occurrences(excerpts),
+      Score:   hit.Score,
+    })
+    if i == len(result.Hits.Values)-1 {
+      hits[i].cursor = (&Cursor{After: hit.Sort}).encode()
+    }
+  }
+  return hits
+}
+
+// search_after a point in time so the export isn't capped by the
+// 10,000 hit index.max_result_window that from and size are. the excerpts
+// are the table's fragments, matches aren't counted
+func (client *ElasticClient) exportHits(p *Parameters, fn func(Hit) error) error {
+  filedLower, filedUpper, err := p.filedRange()
+  if err != nil {
+    return err
+  }
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return err
+  }
+
+  pitId, err := client.openPIT(exportKeepAlive)
+  if err != nil {
+    return err
+  }
+  defer func() { client.closePIT(pitId) }()
+
+  req := newHighlightRequest(q, p.section, companyFilter(p), filedLower, filedUpper, 0, exportBatch)
+  fragments := *p
+  fragments.occurrences = false
+  req.Highlight.pageFragments(&fragments)
+  for {
+    var highlightResult HighlightResult
+    req.PIT = &PIT{Id: pitId, KeepAlive: exportKeepAlive}
+    if err = client.search(req, &highlightResult); err != nil {
+      return err
+    }
+    if highlightResult.PitId != "" {
+      pitId = highlightResult.PitId
+    }
+    for _, hit := range highlightResult.hits(q, p.section) {
+      hit.SectionOccurrences = 0
+      if err = fn(hit); err != nil {
+        return err
+      }
+    }
+    values := highlightResult.Hits.Values
+    if len(values) < exportBatch {
+      return nil
+    }
+    req.SearchAfter = values[len(values)-1].Sort
+  }
+}
+
+func (client *ElasticClient) openPIT(keepAlive string) (string, error) {
+  res, err := client.es.OpenPointInTime([]string{config.IndexName}, keepAlive)
+  if err != nil {
+    return "", err
+  }
+  defer res.Body.Close()
+  if res.IsError() {
+    return "", fmt.Errorf("error opening point in time: %s", res.String())
+  }
+  var pit struct {
+    Id string `json:"id"`
+  }
+  err = json.NewDecoder(res.Body).Decode(&pit)
+  return pit.Id, err
+}
+
+// points in time expire after their keep alive anyway, so only log errors
+func (client *ElasticClient) closePIT(id string) {
+  body, err := json.Marshal(map[string]string{"id": id})
+  if err != nil {
+    log.Printf("error closing point in time: %s\n", err)
+    return
+  }
+  res, err := client.es.ClosePointInTime(client.es.ClosePointInTime.WithBody(bytes.NewReader(body)))
+  if err != nil {
+    log.Printf("error closing point in time: %s\n", err)
+    return
+  }
+  res.Body.Close()
+}
+
+func (client *ElasticClient) facets() (*Facets, error) {
+  var facetsResult FacetsResult
+  if err := client.search(facetsRequest, &facetsResult); err != nil {
+    return nil, err
+  }
+  var stockIndices []string
+  for _, b := range facetsResult.Aggregations.StockIndices.Buckets {
+    stockIndices = append(stockIndices, b.Key)
+  }
+  var sectors []string
+  for _, b := range facetsResult.Aggregations.Sectors.Buckets {
+    sectors = append(sectors, b.Key)
+  }
+  return newFacets(facetsResult.Aggregations.First.Date, facetsResult.Aggregations.Last.Date,
+    stockIndices, sectors)
+}
@@ -0,0 +1,362 @@
+// unittests for elasticsearch request construction
+package main
+
+import (
+  "strings"
+  "testing"
+  "html/template"
+  "encoding/json"
+  "unicode/utf8"
+)
+
+// test that user input in the search term and stock index reaches
+// elasticsearch unchanged, however it would have broken a JSON template
+func TestHighlightRequest(t *testing.T) {
+  cases := [][2]string {
+    // input, phrase searched
+    {`she said "hello"`, `she said "hello"`},
+    {`back\slash \" "`, `back\slash \" "`},
+    {`{"match_all": {}}`, `{"match_all": {}}`},
+    {`"}]}}, "size": 10000, "x": [{"`, `"}]}}, "size": 10000, "x": [{"`},
+    {`Société Générale 中国 ✓`, `Société Générale 中国 ✓`},
+    // quoted phrases are trimmed, white space inside is kept
+    {"tab\tnewline\n", "tab\tnewline"},
+    {`it's`, `it's`},
+    {`'rock 'n'`, `'rock 'n'`},
+    {`it's "both" ''`, `it's "both" ''`},
+  }
+  for _, c := range cases {
+    input := c[0]
+    // single quotes hold the input as one literal phrase, whatever it
+    // contains, with its own single quotes doubled
+    q, err := parseQuery("'" + strings.ReplaceAll(input, "'", "''") + "'")
+    if err != nil {
+      t.Fatalf("parseQuery(%s) error: %s.", input, err)
+    }
+    req := newHighlightRequest(q, sections[0], companyFilter(&Parameters{stockIndex: input}),
+      "2011-12-31", "2013-01-01", 15, 15)
+    b, err := json.Marshal(req)
+    if err != nil {
+      t.Fatalf("marshal error: %s.", err)
+    }
+
+    var decoded SearchRequest
+    if err = json.Unmarshal(b, &decoded); err != nil {
+      t.Fatalf("request for %s is invalid JSON: %s.", input, err)
+    }
+    filter := decoded.Query.Bool.Filter
+    phrase := decoded.Query.Bool.Must[0].MatchPhrase[sections[0]]
+    if phrase != c[1] || len(filter) != 2 || filter[0].Term["StockIndex.keyword"] != input {
+      t.Fatalf("request %s, expected phrase %q and stock index %s.", b, c[1], input)
+    }
+    if decoded.From != 15 || decoded.Size != 15 || decoded.Highlight.Encoder != "html" ||
+       !decoded.TrackTotalHits {
+      t.Fatalf("request %s, expected from, size, highlight and total hits unchanged.", b)
+    }
+  }
+}
+
+// test paging with search_after in a point in time, as the export and
+// table cursors do
+func TestSearchAfterRequest(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  req := newHighlightRequest(q, sections[0], companyFilter(&Parameters{stockIndex: defaultStockIndex}),
+    "2011-12-31", "2013-01-01", 0, exportBatch)
+  req.PIT = &PIT{Id: "abc==", KeepAlive: exportKeepAlive}
+  req.SearchAfter = []json.RawMessage{json.RawMessage(`1330387200000`), json.RawMessage(`42`)}
+  b, err := json.Marshal(req)
+  if err != nil {
+    t.Fatalf("marshal error: %s.", err)
+  }
+
+  var decoded map[string]any
+  if err = json.Unmarshal(b, &decoded); err != nil {
+    t.Fatalf("request is invalid JSON: %s.", err)
+  }
+  if _, ok := decoded["from"]; ok || decoded["size"] != float64(exportBatch) {
+    t.Fatalf("request %s, expected no from and size %d.", b, exportBatch)
+  }
+  sort, _ := json.Marshal(decoded["sort"])
+  pit, _ := json.Marshal(decoded["pit"])
+  searchAfter, _ := json.Marshal(decoded["search_after"])
+  if string(sort) != `[{"Filed":{"order":"desc","unmapped_type":"date"}},` +
+     `{"Url.keyword":{"order":"desc","unmapped_type":"keyword"}}]` ||
+     string(pit) != `{"id":"abc==","keep_alive":"1m"}` || string(searchAfter) != `[1330387200000,42]` {
+    t.Fatalf("request %s, expected tiebreaker sort, point in time and search_after.", b)
+  }
+
+  // the last hit of a page has the cursor to the next
+  var result HighlightResult
+  err = json.Unmarshal([]byte(`{"pit_id": "def==", "hits": {"total": {"value": 3}, "hits": [
+    {"_id": "1", "_source": {"Ticker": "ABC"}, "sort": [1330387200000, "https://sec.gov/7"]},
+    {"_id": "2", "_source": {"Ticker": "XYZ"}, "sort": [1330387200000, "https://sec.gov/3"]}]}}`), &result)
+  if err != nil {
+    t.Fatalf("unmarshal error: %s.", err)
+  }
+  hits := result.hits(q, sections[0])
+  cursor, err := decodeCursor(hits[1].cursor)
+  if hits[0].cursor != "" || err != nil || string(cursor.After[0]) != "1330387200000" ||
+     string(cursor.After[1]) != `"https://sec.gov/3"` {
+    t.Fatalf("cursors %s %s, decoded %v %v, expected the last hit's sort values.",
+      hits[0].cursor, hits[1].cursor, cursor, err)
+  }
+
+  // the first page and the pages after a cursor are in the same order
+  p := Parameters{searchTerm: "cloud", stockIndex: defaultStockIndex, section: sections[0],
+                  page: 1, fragments: 1, fragmentSize: 200}
+  first := newPageRequest(q, &p, nil, "2011-12-31", "2013-01-01", pageSz)
+  p.page = 2
+  next := newPageRequest(q, &p, cursor, "2011-12-31", "2013-01-01", pageSz)
+  firstSort, _ := json.Marshal(first.Sort)
+  nextSort, _ := json.Marshal(next.Sort)
+  if string(firstSort) != string(nextSort) || first.PIT != nil || next.PIT != nil ||
+     next.From != 0 || len(next.SearchAfter) != 2 {
+    t.Fatalf("sorts %s and %s, expected the same sort and the second after the cursor.",
+      firstSort, nextSort)
+  }
+  if jumped := newPageRequest(q, &p, nil, "2011-12-31", "2013-01-01", pageSz); jumped.From != pageSz {
+    t.Fatalf("from %d, expected a page jumped to to start from %d.", jumped.From, pageSz)
+  }
+  if _, err = decodeCursor("not a cursor"); err == nil {
+    t.Fatalf("decodeCursor of an invalid cursor, expected an error.")
+  }
+}
+
+// test tickers replace the stock index filter
+func TestTickersFilter(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  req := newHighlightRequest(q, sections[0], companyFilter(&Parameters{
+    stockIndex: defaultStockIndex, tickers: []string{"AAPL", "MSFT"}}),
+    "2011-12-31", "2013-01-01", 0, 15)
+  b, _ := json.Marshal(req.Query.Bool.Filter)
+  expected := `[{"terms":{"Ticker.keyword":["AAPL","MSFT"]}},{"range":{"Filed":{"gt":"2011-12-31","lt":"2013-01-01"}}}]`
+  if string(b) != expected {
+    t.Fatalf("filter %s, expected %s.", b, expected)
+  }
+  b, _ = json.Marshal(newFilingsRequest(sections[1], companyFilter(&Parameters{
+    stockIndex: defaultStockIndex, tickers: []string{"AAPL"}}), filingsMetric).Query.Bool.Filter)
+  expected = `[{"terms":{"Ticker.keyword":["AAPL"]}},{"bool":{"must_not":[{"term":{"` + sections[1] +
+    `.keyword":""}}],"filter":[{"exists":{"field":"` + sections[1] + `"}}]}}]`
+  if string(b) != expected {
+    t.Fatalf("filter %s, expected %s.", b, expected)
+  }
+}
+
+// test the sector filter and counting by sector
+func TestSectorRequest(t *testing.T) {
+  companies := companyFilter(&Parameters{stockIndex: defaultStockIndex, sector: "Services"})
+  b, _ := json.Marshal(companies)
+  expected := `[{"term":{"StockIndex.keyword":"S\u0026P 500"}},{"term":{"Sector.keyword":"Services"}}]`
+  if string(b) != expected {
+    t.Fatalf("filter %s, expected %s.", b, expected)
+  }
+
+  q, _ := parseQuery("cloud")
+  req := newSectorRequest(q, companies, filingsMetric)
+  if len(req.Query.Bool.Must[0].Bool.Should) != len(primarySections) {
+    t.Fatalf("query %v, expected matches in any section.", req.Query)
+  }
+  b, _ = json.Marshal(req.Aggs)
+  expected = `{"sectors":{"terms":{"field":"Sector.keyword","size":11},` +
+    `"aggs":{"year":{"date_histogram":{"field":"Filed","calendar_interval":"1y"}}}}}`
+  if string(b) != expected {
+    t.Fatalf("aggs %s, expected %s.", b, expected)
+  }
+  if req = newSectorRequest(nil, companies, filingsMetric); req.Query.Bool.Must != nil {
+    t.Fatalf("query %v, expected every filing for a nil query.", req.Query)
+  }
+}
+
+// test ranking companies by matching filings
+func TestTopCompaniesRequest(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  req := newTopCompaniesRequest(q, companyFilter(&Parameters{stockIndex: defaultStockIndex}), 10)
+  if len(req.Query.Bool.Must[0].Bool.Should) != len(primarySections) || req.Size != 0 {
+    t.Fatalf("query %v, expected matches in any section and no hits.", req.Query)
+  }
+  b, _ := json.Marshal(req.Aggs)
+  expected := `{"companies":{"terms":{"field":"Ticker.keyword","size":10},"aggs":{` +
+    `"first":{"min":{"field":"Filed"}},"last":{"max":{"field":"Filed"}},` +
+    `"name":{"terms":{"field":"Name.keyword","size":1}}}}}`
+  if string(b) != expected {
+    t.Fatalf("aggs %s, expected %s.", b, expected)
+  }
+}
+
+// test counting by company and year in pages
+func TestTimelineRequest(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  req := newTimelineRequest(q, sections[1], companyFilter(&Parameters{stockIndex: defaultStockIndex}))
+  req.Aggs["timeline"].Composite.After = map[string]any{"ticker": "ABC", "name": "Abc Inc",
+    "year": "2012"}
+  b, _ := json.Marshal(req.Aggs)
+  expected := `{"timeline":{"composite":{"size":1000,"sources":[` +
+    `{"ticker":{"terms":{"field":"Ticker.keyword"}}},{"name":{"terms":{"field":"Name.keyword"}}},` +
+    `{"year":{"date_histogram":{"field":"Filed","calendar_interval":"1y","format":"yyyy"}}}],` +
+    `"after":{"name":"Abc Inc","ticker":"ABC","year":"2012"}}}}`
+  if string(b) != expected {
+    t.Fatalf("aggs %s, expected %s.", b, expected)
+  }
+}
+
+// test looking up a company's filing with a section
+func TestFilingSectionRequest(t *testing.T) {
+  b, _ := json.Marshal(newFilingSectionRequest("ABC", sections[1], 2013))
+  expected := `{"_source":["Ticker","Name","Filed","Url","` + sections[1] + `"],"query":{"bool":` +
+    `{"filter":[{"term":{"Ticker.keyword":"ABC"}},{"range":{"Filed":{"gt":"2012-12-31",` +
+    `"lt":"2014-01-01"}}},{"bool":{"must_not":[{"term":{"` + sections[1] + `.keyword":""}}],` +
+    `"filter":[{"exists":{"field":"` + sections[1] + `"}}]}}]}},` +
+    `"sort":[{"Filed":{"order":"desc"}}],"size":1}`
+  if string(b) != expected {
+    t.Fatalf("request %s, expected %s.", b, expected)
+  }
+}
+
+// test a filing's whole sections are highlighted
+func TestFilingRequest(t *testing.T) {
+  req := newFilingRequest("0000320193-23-000106", nil)
+  b, _ := json.Marshal(req.Query)
+  if string(b) != `{"bool":{"filter":[{"ids":{"values":["0000320193-23-000106"]}}]}}` ||
+     req.Highlight != nil || len(req.Source) != 4+len(primarySections) {
+    t.Fatalf("query %s, expected the filing's sections without highlights.", b)
+  }
+
+  q, _ := parseQuery("cloud")
+  req = newFilingRequest("0000320193-23-000106", q)
+  if len(req.Query.Bool.Should) != len(primarySections) ||
+     req.Query.Bool.Should[1].MatchPhrase[sections[1]] != "cloud" {
+    t.Fatalf("query %v, expected the search term in each section.", req.Query)
+  }
+  b, _ = json.Marshal(req.Highlight)
+  if !strings.Contains(string(b), `"number_of_fragments":0`) {
+    t.Fatalf("highlight %s, expected whole sections.", b)
+  }
+}
+
+// test the companies metric counts tickers in each year bucket
+func TestCompaniesMetric(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  for _, req := range []SearchRequest{
+    newHistogramRequest(q, sections[0], companyFilter(&Parameters{stockIndex: defaultStockIndex}),
+      companiesMetric),
+    newFilingsRequest(sections[0], companyFilter(&Parameters{stockIndex: defaultStockIndex}),
+      companiesMetric),
+  } {
+    b, _ := json.Marshal(req.Aggs)
+    expected := `{"year":{"date_histogram":{"field":"Filed","calendar_interval":"1y"},` +
+      `"aggs":{"companies":{"cardinality":{"field":"Ticker.keyword"}}}}}`
+    if string(b) != expected {
+      t.Fatalf("aggs %s, expected %s.", b, expected)
+    }
+  }
+  b, _ := json.Marshal(newHistogramRequest(q, sections[0],
+    companyFilter(&Parameters{stockIndex: defaultStockIndex}), filingsMetric).Aggs)
+  if string(b) != `{"year":{"date_histogram":{"field":"Filed","calendar_interval":"1y"}}}` {
+    t.Fatalf("aggs %s, expected only the date histogram.", b)
+  }
+}
+
+// test percents of filings, rounded and zero without filings
+func TestShareOfFilings(t *testing.T) {
+  counts := map[string](map[string]int){
+    sections[0]: {"2012": 1, "2013": 2, "2014": 5},
+    sections[1]: {"2013": 0},
+  }
+  filings := map[string](map[string]int){
+    sections[0]: {"2012": 3, "2013": 8},
+    sections[1]: {"2013": 10},
+  }
+  shares := shareOfFilings(counts, filings)
+  if shares[sections[0]]["2012"] != 33.33 || shares[sections[0]]["2013"] != 25 ||
+     shares[sections[0]]["2014"] != 0 || shares[sections[1]]["2013"] != 0 {
+    t.Fatalf("shares %v, expected 33.33 and 25 percent.", shares)
+  }
+}
+
+// test searching all sections at once and picking the excerpt to show
+func TestAllSections(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  should := filteredQuery(q, allSections, companyFilter(&Parameters{stockIndex: defaultStockIndex})).Bool.Must[0].Bool.Should
+  if len(should) != len(primarySections) {
+    t.Fatalf("should %v, expected one clause per section, without derived ones.", should)
+  }
+  for i, s := range primarySections {
+    if should[i].Bool.Name != s || should[i].Bool.Must[0].MatchPhrase[s] != "cloud" {
+      t.Fatalf("clause %v, expected the query in section %s named after it.", should[i], s)
+    }
+  }
+
+  section, excerpt := bestExcerpt(map[string]template.HTML{
+    sections[0]: "<em>cloud</em> storage",
+    sections[1]: "<em>cloud</em> outage, <em>cloud</em> breach",
+  })
+  if section != sections[1] || excerpt != "<em>cloud</em> outage, <em>cloud</em> breach" {
+    t.Fatalf("bestExcerpt = %s, %s, expected the excerpt with most highlights.", section, excerpt)
+  }
+}
+
+// test whole highlighted sections are cut into excerpts around their matches
+func TestCutExcerpts(t *testing.T) {
+  filler := strings.Repeat("and more words ", 10)
+  hit := Hit{Excerpt: template.HTML("We sell <em>cloud</em> services " + filler +
+    "a <em>cloud</em> outage and <em>cloud</em> costs " + filler + "the <em>cloud</em> end.")}
+  hit.cutExcerpts(2, 60)
+  if len(hit.Excerpts) != 2 || hit.Excerpt != hit.Excerpts[0] ||
+     !strings.HasPrefix(string(hit.Excerpts[0]), "We sell <em>cloud</em>") ||
+     strings.Count(string(hit.Excerpts[1]), "<em>") != 2 {
+    t.Fatalf("excerpts %q, expected the first match and the two close together.", hit.Excerpts)
+  }
+  for _, excerpt := range hit.Excerpts {
+    if len(excerpt) > 80 || strings.Count(string(excerpt), "<em>") != strings.Count(string(excerpt), "</em>") {
+      t.Fatalf("excerpt %q, expected about 60 characters without a cut highlight.", excerpt)
+    }
+  }
+
+  // a merged NEAR span longer than the excerpt size is kept whole
+  q, _ := parseQuery("tariff NEAR/50 China")
+  span := "<em>tariff</em> " + strings.Repeat("and more words ", 14) + "<em>China</em>"
+  hit = Hit{Excerpt: q.mergeNearHighlights(template.HTML("Before the span, " + span + " after it."))}
+  hit.cutExcerpts(1, 200)
+  if len(hit.Excerpts) != 1 || !strings.Contains(string(hit.Excerpt),
+     "<em>tariff " + strings.Repeat("and more words ", 14) + "China</em>") {
+    t.Fatalf("excerpts %q, expected the whole NEAR span.", hit.Excerpts)
+  }
+
+  hit = Hit{Excerpt: template.HTML(filler)}
+  hit.cutExcerpts(3, 60)
+  if len(hit.Excerpts) != 1 || !strings.HasPrefix(string(hit.Excerpt), "and more words") {
+    t.Fatalf("excerpts %q, expected the start of a section without matches.", hit.Excerpts)
+  }
+
+  // escaped, non-ASCII text is cut between words, wherever the size falls
+  text := "Ventes à l&#39;étranger — Überseeumsätze&amp;Gewinne 中国市场 <em>tarifs</em>/" +
+    "douaniers/R&amp;D/coûts/élevés/中国市场&amp;风险 <em>tarifs</em> fin"
+  for size := 1; size < len(text); size++ {
+    hit = Hit{Excerpt: template.HTML(text)}
+    hit.cutExcerpts(2, size)
+    for _, excerpt := range hit.Excerpts {
+      e := string(excerpt)
+      if !utf8.ValidString(e) || strings.Count(e, "<em>") != strings.Count(e, "</em>") ||
+         !strings.HasPrefix(text, e) && !strings.Contains(text, " " + e) ||
+         !strings.HasSuffix(text, e) && !strings.Contains(text, e + " ") {
+        t.Fatalf("size %d excerpt %q, expected whole words.", size, e)
+      }
+    }
+  }
+}
+
+// test pages of hits ask for their fragments, or whole sections to count in
+func TestPageFragments(t *testing.T) {
+  q, _ := parseQuery("cloud")
+  req := newHighlightRequest(q, sections[0], nil, "2011-12-31", "2013-01-01", 0, 15)
+  req.Highlight.pageFragments(&Parameters{fragments: 3, fragmentSize: 120})
+  b, _ := json.Marshal(req.Highlight)
+  if !strings.Contains(string(b), `"fragment_size":120,"number_of_fragments":3,`) {
+    t.Fatalf("highlight %s, expected 3 fragments of 120 characters.", b)
+  }
+  req.Highlight.pageFragments(&Parameters{fragments: 3, fragmentSize: 120, occurrences: true})
+  b, _ = json.Marshal(req.Highlight)
+  if !strings.Contains(string(b), `"number_of_fragments":0,`) {
+    t.Fatalf("highlight %s, expected whole sections.", b)
+  }
+}
@@ -7,40 +7,151 @@ import (
   "math"
   "bytes"
   "strconv"
+  "regexp"
+  "strings"
+  "unicode"
+  "net/url"
   "net/http"
   "html/template"
+  "github.com/kyleleelarson/sec-search/config"
 )
 
 const pageSz = 15 // rows in table to display
-var es *ElasticClient
-var templates = template.Must(template.ParseFiles("./html/table.html", "./html/index.html"))
-var sections = [2]string {"1. Business","1A. Risk Factors"}
-var years = [20]string {"2005","2006","2007","2008","2009","2010","2011","2012","2013","2014",
-                        "2015","2016","2017","2018","2019","2020","2021","2022","2023","2024"}
+const maxTerms = 5 // search terms to compare on the graph
+const maxTickers = 500 // companies in a tickers filter
+const topCompaniesSz = 10 // companies ranked next to the graph
+// excerpts of each hit shown, and about how many characters each is
+const (
+  defaultFragments    = "1"
+  maxFragments        = 10
+  defaultFragmentSize = "200"
+  minFragmentSize     = 50
+  maxFragmentSize     = 1000
+)
+var searcher Searcher
+var templates = template.Must(template.New("").Funcs(templateFuncs).
+  ParseFiles("./html/table.html", "./html/index.html", "./html/timeline.html",
+  "./html/diff.html", "./html/filing.html"))
+// stock indices to choose from in the search forms, and the all sections choice
+var templateFuncs = template.FuncMap{
+  "stockIndices": func() []string { return currentFacets().StockIndices },
+  "allSections":  func() string { return allSections },
+  "sectors":      func() []string { return currentFacets().Sectors },
+}
+var sections = config.SectionNames()
+// the sections searched for all sections and graphed, see config.Section
+var primarySections = config.PrimarySectionNames()
+var validTicker = regexp.MustCompile(`^[A-Z0-9.\-]{1,10}$`)
+const allSections = "All sections" // section parameter to search every section
+// filing years until the index is first queried, see facets.go
 const yearUpperBound = 2024
 const yearLowerBound = 2005
-const defaultYear       = "2024"
 const defaultStockIndex = "S&P 500"
-const defaultSection    = "1. Business"
 const defaultPage       = "1"
+// what the graph counts each year
+const (
+  filingsMetric   = "filings"
+  companiesMetric = "companies" // a company filing twice in a year counts once
+)
+// what the graph's bars are stacked by
+const (
+  sectionBreakdown = "section"
+  sectorBreakdown  = "sector" // matches in any section, single search term only
+)
+var defaultSection      = config.Sections[0].Name
 
 // struct of query string parameters to pass around                        
 type Parameters struct {
-  searchTerm string   
+  searchTerm string   // the term in the table, one of terms
+  terms      []string // every term on the graph, compared when more than one
+  tab        int      // index of searchTerm in terms
   stockIndex string   
+  tickers    []string // companies searched in place of the stock index when set
+  sector     string   // only companies in the sector when set
   section    string   
   year       string   
   page       int   
+  from       string   // from and to override year when either is set
+  to         string   
+  cursor     string   // resumes after the previous page when set, see Cursor
+  normalize  bool     // graph the share of all filings instead of counts
+  metric     string   // filingsMetric or companiesMetric
+  breakdown  string   // sectionBreakdown or sectorBreakdown
+  fragments  int      // excerpts per hit
+  fragmentSize int
+  occurrences bool    // count every match in the hits, searching whole sections
+}
+
+// exclusive bounds on Filed for the year or from, to range
+func (p *Parameters) filedRange() (string, string, error) {
+  if p.from == "" && p.to == "" {
+    lower, upper := processYear(p.year)
+    return lower, upper, nil
+  }
+  return processRange(p.from, p.to)
+}
+
+// label for the from, to range, empty when searching a single year
+func (p *Parameters) rangeLabel() string {
+  if p.from == "" && p.to == "" {
+    return ""
+  }
+  from, to := p.from, p.to
+  if from == "" {
+    from = strconv.Itoa(currentFacets().FirstYear)
+  }
+  if to == "" {
+    to = strconv.Itoa(currentFacets().LastYear)
+  }
+  return from + " to " + to
+}
+
+type HomeData struct {
+  Sections []string
 }
 
 type TableData struct {
+  Terms []string // a tab per term when comparing
+  Tab   int
   Page  int
   Pages int
+  Range   string // from, to range in place of Year when set
+  From    string
+  To      string
   Year    string
   Section string
   Years    []string
   Sections []string
-  Hits [](map[string]any)
+  Hits []Hit
+  Next   string // cursor to the next page
+  Export string // CSV of every hit
+  SearchTerm string
+  Occurrences bool // hits have their matches counted
+  Top    []TopCompany // with the graph, not when filtering the table
+}
+
+// a ranked company linked to the search narrowed to it
+type TopCompany struct {
+  CompanyCount
+  Url string
+}
+
+// link to the search for one company, its hits in any section over the
+// years it matched
+func companyURL(p *Parameters, c CompanyCount) string {
+  v := url.Values{}
+  v["searchterm"] = p.terms
+  v.Set("tab", strconv.Itoa(p.tab))
+  v.Set("stockindex", p.stockIndex)
+  v.Set("tickers", c.Ticker)
+  v.Set("section", allSections)
+  v.Set("from", c.FirstYear)
+  v.Set("to", c.LastYear)
+  v.Set("metric", p.metric)
+  if p.normalize {
+    v.Set("normalize", "true")
+  }
+  return "/search?" + v.Encode()
 }
 
 func prepareTable(p *Parameters) (*TableData, error) {
@@ -50,18 +161,32 @@ func prepareTable(p *Parameters) (*TableData, error) {
     err error
   )
 
-  total, tableData.Hits, err = es.highlightSearch(p.searchTerm, p.stockIndex, 
-                                                            p.section, p.year, p.page, pageSz)
+  total, tableData.Hits, err = searcher.highlightSearch(p, pageSz)
   if err != nil {
     return &tableData, err
   }
 
+  if len(p.terms) > 1 {
+    tableData.Terms = p.terms
+  }
+  tableData.Tab = p.tab
+  tableData.Occurrences = p.occurrences
   tableData.Page = p.page
   tableData.Pages = int(math.Ceil(float64(total) / float64(pageSz)))
-  tableData.Year = p.year
+  if len(tableData.Hits) > 0 && p.page < tableData.Pages {
+    tableData.Next = tableData.Hits[len(tableData.Hits)-1].cursor
+  }
+  tableData.Range = p.rangeLabel()
+  tableData.From = p.from
+  tableData.To = p.to
+  if tableData.Range == "" {
+    tableData.Year = p.year
+  }
   tableData.Section = p.section
-  for _, y := range years {
-    if y != p.year {
+  tableData.Export = exportURL(p)
+  tableData.SearchTerm = p.searchTerm
+  for _, y := range currentFacets().years() {
+    if y != tableData.Year {
       tableData.Years = append(tableData.Years, y)
     }
   }
@@ -70,6 +195,9 @@ func prepareTable(p *Parameters) (*TableData, error) {
       tableData.Sections = append(tableData.Sections, s)
     }
   }
+  if p.section != allSections {
+    tableData.Sections = append(tableData.Sections, allSections)
+  }
 
   return &tableData, err
 }
@@ -82,7 +210,7 @@ func home(w http.ResponseWriter, r *http.Request) {
   // log request
   log.Printf(",%s,HOME\n", r.URL.Path)
 
-  err = templates.ExecuteTemplate(&buf, "index.html", nil)
+  err = templates.ExecuteTemplate(&buf, "index.html", HomeData{Sections: sections})
   if err != nil {
     http.Error(w, "template error", http.StatusInternalServerError)
     log.Printf("execute template error for index.html: %s\n", err.Error())
@@ -125,7 +253,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
     err error
   )
 
-  counts, err := es.histogramSearch(p.searchTerm, p.stockIndex)
+  series, counts, shares, err := histogram(p)
   if err != nil {
     http.Error(w, "histogram search error", http.StatusInternalServerError)
     log.Printf("in searchHandler with search term '%s', histogram search error: %s\n",
@@ -133,7 +261,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
     return
   }
 
-  err = renderGraph(counts, p, &buf)
+  err = renderGraph(series, counts, shares, p, &buf)
   if err != nil {
     http.Error(w, "graph render error", http.StatusInternalServerError)
     log.Printf("in searchHandler with search term '%s', render graph error: %s\n",
@@ -149,6 +277,17 @@ func searchHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
     return
   }
 
+  top, err := searcher.topCompanies(p, topCompaniesSz)
+  if err != nil {
+    http.Error(w, "top companies search error", http.StatusInternalServerError)
+    log.Printf("in searchHandler with search term '%s', top companies search error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+  for _, c := range top {
+    tableData.Top = append(tableData.Top, TopCompany{CompanyCount: c, Url: companyURL(p, c)})
+  }
+
   err = templates.ExecuteTemplate(&buf, "table.html", tableData)
   if err != nil {
     http.Error(w, "template error", http.StatusInternalServerError)
@@ -160,6 +299,80 @@ func searchHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
   fmt.Fprintf(w, "%s", buf.String())
 }
 
+// matching filings per year for each series, the sections the search term
+// is in, the sectors of the companies or, comparing terms, each term in any
+// section. when normalizing also their percent of all filings with the
+// section or in the sector, nil otherwise
+func histogram(p *Parameters) ([]string, map[string](map[string]int),
+  map[string](map[string]float64), error) {
+
+  if p.breakdown == sectorBreakdown {
+    return sectorHistogram(p)
+  }
+
+  if len(p.terms) < 2 {
+    counts, shares, err := sectionHistogram(p, primarySections)
+    return primarySections, counts, shares, err
+  }
+
+  counts := make(map[string](map[string]int))
+  var shares map[string](map[string]float64)
+  if p.normalize {
+    shares = make(map[string](map[string]float64))
+  }
+  for _, term := range p.terms {
+    termP := *p
+    termP.searchTerm = term
+    termCounts, termShares, err := sectionHistogram(&termP, []string{allSections})
+    if err != nil {
+      return p.terms, counts, shares, err
+    }
+    counts[term] = termCounts[allSections]
+    if p.normalize {
+      shares[term] = termShares[allSections]
+    }
+  }
+  return p.terms, counts, shares, nil
+}
+
+func sectionHistogram(p *Parameters, searched []string) (map[string](map[string]int),
+  map[string](map[string]float64), error) {
+
+  counts, err := searcher.histogramSearch(p, searched)
+  if err != nil || !p.normalize {
+    return counts, nil, err
+  }
+  filings, err := searcher.filingCounts(p, searched)
+  if err != nil {
+    return counts, nil, err
+  }
+  return counts, shareOfFilings(counts, filings), nil
+}
+
+// series are the sectors with matches, in the order of config.Sectors
+func sectorHistogram(p *Parameters) ([]string, map[string](map[string]int),
+  map[string](map[string]float64), error) {
+
+  var series []string
+  counts, err := searcher.sectorCounts(p, false)
+  if err != nil {
+    return series, counts, nil, err
+  }
+  for _, s := range config.Sectors {
+    if _, ok := counts[s.Name]; ok {
+      series = append(series, s.Name)
+    }
+  }
+  if !p.normalize {
+    return series, counts, nil, nil
+  }
+  filings, err := searcher.sectorCounts(p, true)
+  if err != nil {
+    return series, counts, nil, err
+  }
+  return series, counts, shareOfFilings(counts, filings), nil
+}
+
 // helper function to check request parameters and supply defaults
 func paramStr(r *http.Request, name string, def string) string {
   var param string
@@ -170,43 +383,184 @@ func paramStr(r *http.Request, name string, def string) string {
   return param
 }
 
+// upper case tickers separated by commas, semicolons or white space,
+// without duplicates
+func parseTickers(s string) ([]string, error) {
+  var tickers []string
+  seen := make(map[string]bool)
+  for _, ticker := range strings.FieldsFunc(s, func(r rune) bool {
+    return r == ',' || r == ';' || unicode.IsSpace(r)
+  }) {
+    ticker = strings.ToUpper(ticker)
+    if !validTicker.MatchString(ticker) {
+      return nil, fmt.Errorf("invalid ticker '%s'", ticker)
+    }
+    if !seen[ticker] {
+      seen[ticker] = true
+      tickers = append(tickers, ticker)
+    }
+  }
+  if len(tickers) > maxTickers {
+    return nil, fmt.Errorf("at most %d tickers", maxTickers)
+  }
+  return tickers, nil
+}
+
+// read and check the query string parameters, the error is shown to the user
+func parseParameters(r *http.Request) (*Parameters, error) {
+  var (
+    p Parameters
+    err error
+  )
+
+  if err = r.ParseForm(); err != nil {
+    return nil, fmt.Errorf("invalid query string")
+  }
+  // blank terms are left out, but keep one so there's something to search
+  for _, term := range r.Form["searchterm"] {
+    if strings.TrimSpace(term) != "" {
+      p.terms = append(p.terms, term)
+    }
+  }
+  if len(p.terms) == 0 {
+    p.terms = []string{r.FormValue("searchterm")}
+  }
+  tabStr      := paramStr(r, "tab",        "0")
+  p.stockIndex = paramStr(r, "stockindex", defaultStockIndex)
+  tickersStr  := r.FormValue("tickers")
+  p.sector     = r.FormValue("sector")
+  p.section    = paramStr(r, "section",    defaultSection)
+  p.year       = paramStr(r, "year",       strconv.Itoa(currentFacets().LastYear))
+  pageStr     := paramStr(r, "p",          defaultPage)
+  p.from       = r.FormValue("from")
+  p.to         = r.FormValue("to")
+  p.cursor     = r.FormValue("cursor")
+  normalizeStr := paramStr(r, "normalize", "false")
+  p.metric     = paramStr(r, "metric",     filingsMetric)
+  p.breakdown  = paramStr(r, "breakdown",  sectionBreakdown)
+  fragmentsStr := paramStr(r, "fragments", defaultFragments)
+  fragmentSizeStr := paramStr(r, "fragment_size", defaultFragmentSize)
+  occurrencesStr := paramStr(r, "occurrences", "false")
+
+  p.page, err = strconv.Atoi(pageStr)
+  if err != nil || p.page < 1 {
+    return nil, fmt.Errorf("invalid page parameter")
+  }
+
+  if len(p.terms) > maxTerms {
+    return nil, fmt.Errorf("at most %d search terms", maxTerms)
+  }
+  for _, term := range p.terms {
+    if _, err = parseQuery(term); err != nil {
+      return nil, fmt.Errorf("invalid search term: %s", err)
+    }
+  }
+
+  p.tab, err = strconv.Atoi(tabStr)
+  if err != nil || p.tab < 0 || p.tab >= len(p.terms) {
+    return nil, fmt.Errorf("invalid tab parameter")
+  }
+  p.searchTerm = p.terms[p.tab]
+
+  if _, _, err = p.filedRange(); err != nil {
+    return nil, err
+  }
+
+  if _, err = decodeCursor(p.cursor); err != nil {
+    return nil, fmt.Errorf("invalid cursor parameter")
+  }
+
+  p.normalize, err = strconv.ParseBool(normalizeStr)
+  if err != nil {
+    return nil, fmt.Errorf("invalid normalize parameter")
+  }
+
+  if p.metric != filingsMetric && p.metric != companiesMetric {
+    return nil, fmt.Errorf("invalid metric parameter")
+  }
+
+  if p.tickers, err = parseTickers(tickersStr); err != nil {
+    return nil, err
+  }
+
+  if _, ok := config.FindSector(p.sector); p.sector != "" && !ok {
+    return nil, fmt.Errorf("invalid sector parameter")
+  }
+
+  if p.breakdown != sectionBreakdown && p.breakdown != sectorBreakdown {
+    return nil, fmt.Errorf("invalid breakdown parameter")
+  }
+  if p.breakdown == sectorBreakdown && len(p.terms) > 1 {
+    return nil, fmt.Errorf("sector breakdown takes one search term")
+  }
+
+  p.fragments, err = strconv.Atoi(fragmentsStr)
+  if err != nil || p.fragments < 1 || p.fragments > maxFragments {
+    return nil, fmt.Errorf("invalid fragments parameter")
+  }
+  p.fragmentSize, err = strconv.Atoi(fragmentSizeStr)
+  if err != nil || p.fragmentSize < minFragmentSize || p.fragmentSize > maxFragmentSize {
+    return nil, fmt.Errorf("invalid fragment_size parameter")
+  }
+  p.occurrences, err = strconv.ParseBool(occurrencesStr)
+  if err != nil {
+    return nil, fmt.Errorf("invalid occurrences parameter")
+  }
+
+  return &p, nil
+}
+
+// log all requests
+func logRequest(r *http.Request, p *Parameters) {
+  log.Printf(",%s,'%s',%s,%s,%s,%d,%s,%s\n", r.URL.Path, p.searchTerm, p.stockIndex, 
+    p.section, p.year, p.page, p.from, p.to)
+}
+
 func processParameters(fn func (http.ResponseWriter, *http.Request, *Parameters)) http.HandlerFunc {
   return func(w http.ResponseWriter, r *http.Request) {
-    var (
-      p Parameters
-      err error
-    )
-
-    p.searchTerm = r.FormValue("searchterm")
-    p.stockIndex = paramStr(r, "stockindex", defaultStockIndex)
-    p.section    = paramStr(r, "section",    defaultSection)
-    p.year       = paramStr(r, "year",       defaultYear)
-    pageStr     := paramStr(r, "p",          defaultPage)
-
-    p.page, err = strconv.Atoi(pageStr)
-    if err != nil || p.page < 1 {
-      http.Error(w, "invalid page parameter", http.StatusBadRequest)
+    p, err := parseParameters(r)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
       return
     }
+    logRequest(r, p)
+    fn(w, r, p);
+  }
+}
 
-    // log all requests
-    log.Printf(",%s,'%s',%s,%s,%s,%d\n", r.URL.Path, p.searchTerm, p.stockIndex, 
-      p.section, p.year, p.page, )
-
-    fn(w, r, &p);
+// pick the search backend to use, elasticsearch unless told otherwise.
+// sqlite searches the index_builder source database directly
+func newSearcher(backend string) Searcher {
+  switch backend {
+  case "", "elastic":
+    return NewElasticClient()
+  case "sqlite":
+    return NewSQLiteClient()
   }
+  log.Fatalf("Unknown SEARCH_BACKEND '%s'", backend)
+  return nil
 }
 
 func main() {
   port := os.Getenv("PORT")
   if port == "" {
-    log.Fatal("Must set PORT and ES related environmental variables")
+    log.Fatal("Must set PORT and ES or SQLITE related environmental variables")
   }
 
-  es = NewElasticClient()
+  searcher = newSearcher(os.Getenv("SEARCH_BACKEND"))
+  updateFacets(searcher)
+  go refreshFacets(searcher, facetsRefresh)
 
 	http.HandleFunc("/", home)
 	http.HandleFunc("/search", processParameters(searchHandler))
 	http.HandleFunc("/filter", processParameters(updateTableHandler))
+	http.HandleFunc("/export.csv", processParameters(exportHandler))
+	http.HandleFunc("/histogram", processParameters(histogramExportHandler))
+	http.HandleFunc("/timeline", processParameters(timelineHandler))
+	http.HandleFunc("/company/", companyHandler)
+	http.HandleFunc("/filing/", filingHandler)
+	http.HandleFunc("/api/v1/histogram", processAPIParameters(apiHistogramHandler))
+	http.HandleFunc("/api/v1/hits", processAPIParameters(apiHitsHandler))
+	http.HandleFunc("/api/v1/timeline", processAPIParameters(apiTimelineHandler))
 	panic(http.ListenAndServe(port, nil))
 }
@@ -0,0 +1,759 @@
+package main
+
+import (
+  "os"
+  "log"
+  "fmt"
+  "html"
+  "strings"
+  "strconv"
+  "html/template"
+  "encoding/json"
+  "database/sql"
+  _ "github.com/mattn/go-sqlite3"
+  "github.com/kyleleelarson/sec-search/config"
+)
+
+// FTS5 virtual table with a column per section named after its SQLite table,
+// built on first start. go-sqlite3 only includes FTS5 when built with -tags sqlite_fts5
+const ftsTable = config.FTSTable
+
+const sqliteJoin = `
+  FROM filings_fts
+  JOIN filings ON filings.accession_number=filings_fts.accession_number
+  JOIN companies ON companies.ticker=filings.ticker`
+
+// %s are the count for the metric, see sqliteCount, and the companies
+// searched, see companies
+const sqliteHistogramQuery = `
+  SELECT substr(filings.filed_date,1,4) AS year, %s` + sqliteJoin + `
+  WHERE filings_fts MATCH ? AND %s
+  GROUP BY year`
+
+// %s are the count for the metric, a condition on the section's column,
+// see sqliteHasSection, and the companies
+const sqliteFilingsQuery = `
+  SELECT substr(filings.filed_date,1,4) AS year, %s` + sqliteJoin + `
+  WHERE %s AND %s
+  GROUP BY year`
+
+// %s is the companies searched
+const sqliteWhere = `
+  WHERE filings_fts MATCH ? AND %s
+    AND filings.filed_date>? AND filings.filed_date<?`
+
+const sqliteCountQuery = `SELECT count(*)` + sqliteJoin + sqliteWhere
+
+const sqliteHighlightQuery = `
+  SELECT
+    filings.accession_number,
+    -bm25(filings_fts),
+    filings.filed_date,
+    companies.ticker,
+    companies.name,
+    filings.link_10k,
+    %s` + sqliteJoin + sqliteWhere + `%s
+  ORDER BY filings.filed_date DESC, filings.accession_number DESC
+  LIMIT ? OFFSET ?`
+
+// keyset paging after the last hit of the previous page
+const sqliteAfter = `
+    AND (filings.filed_date, filings.accession_number)<(?, ?)`
+
+// sector of a filing's company, see config.SectorSQL
+var sqliteSector = config.SectorSQL("companies." + config.SICColumn)
+
+// %s are the count for the metric, the sector expression, a condition
+// matching the search term or "1" for every filing, and the companies
+const sqliteSectorQuery = `
+  SELECT %[2]s AS sector, substr(filings.filed_date,1,4) AS year, %[1]s` + sqliteJoin + `
+  WHERE %[3]s AND %[4]s
+  GROUP BY sector, year
+  HAVING sector!=''`
+
+// %s is the companies searched
+const sqliteTopCompaniesQuery = `
+  SELECT
+    companies.ticker,
+    companies.name,
+    count(*) AS filings,
+    min(substr(filings.filed_date,1,4)),
+    max(substr(filings.filed_date,1,4))` + sqliteJoin + `
+  WHERE filings_fts MATCH ? AND %s
+  GROUP BY companies.ticker
+  ORDER BY filings DESC, companies.ticker
+  LIMIT ?`
+
+// %s is the companies searched
+const sqliteTimelineQuery = `
+  SELECT companies.ticker, companies.name, substr(filings.filed_date,1,4) AS year, count(*)` +
+  sqliteJoin + `
+  WHERE filings_fts MATCH ? AND %s
+  GROUP BY companies.ticker, year
+  ORDER BY companies.ticker, year`
+
+// %s are the section's column and a condition that the filing has it,
+// see sqliteHasSection
+const sqliteFilingSectionQuery = `
+  SELECT
+    filings.accession_number,
+    companies.ticker,
+    companies.name,
+    filings.filed_date,
+    filings.link_10k,
+    filings_fts.%s` + sqliteJoin + `
+  WHERE companies.ticker=? AND substr(filings.filed_date,1,4)=? AND %s
+  ORDER BY filings.filed_date DESC
+  LIMIT 1`
+
+// %s are the section columns, highlighted or not, and a match condition
+// or "1"
+const sqliteFilingQuery = `
+  SELECT
+    filings.accession_number,
+    companies.ticker,
+    companies.name,
+    filings.filed_date,
+    filings.link_10k,
+    %s` + sqliteJoin + `
+  WHERE filings_fts.accession_number=? AND %s`
+
+type SQLiteClient struct {
+  db *sql.DB
+  hasSIC bool // companies table has SIC codes, older databases don't
+}
+
+func NewSQLiteClient() *SQLiteClient {
+  path := os.Getenv("SQLITE_PATH")
+  if path == "" {
+    path = config.DBPath
+  }
+  // mode=rw so a wrong path is an error instead of a new empty database
+  db, err := sql.Open("sqlite3", "file:" + path + "?mode=rw")
+  if err != nil {
+    log.Fatalf("Error opening database: %s", err)
+  }
+  if err = db.Ping(); err != nil {
+    log.Fatalf("Error opening database %s: %s", path, err)
+  }
+  client := &SQLiteClient{db: db}
+  if client.hasSIC, err = config.HasSICCodes(db); err != nil {
+    log.Fatalf("Error checking for SIC codes: %s", err)
+  }
+  if err = client.buildIndex(); err != nil {
+    log.Fatalf("Error building full-text index (built with -tags sqlite_fts5?): %s", err)
+  }
+  return client
+}
+
+// FTS5 table columns after accession_number
+func ftsColumns() []string {
+  var columns []string
+  for _, s := range config.Sections {
+    columns = append(columns, s.Table)
+  }
+  return columns
+}
+
+// create and fill the FTS5 table if this database doesn't have one yet,
+// or rebuild it if the configured sections changed
+func (client *SQLiteClient) buildIndex() error {
+  var existing []string
+  rows, err := client.db.Query("SELECT name FROM pragma_table_info(?)", ftsTable)
+  if err != nil {
+    return err
+  }
+  for rows.Next() {
+    var name string
+    if err = rows.Scan(&name); err != nil {
+      rows.Close()
+      return err
+    }
+    existing = append(existing, name)
+  }
+  rows.Close()
+
+  columns := strings.Join(ftsColumns(), ", ")
+  if len(existing) > 0 && strings.Join(existing[1:], ", ") == columns {
+    return nil
+  }
+  if len(existing) > 0 {
+    log.Printf("sections changed, dropping %s\n", ftsTable)
+    if _, err = client.db.Exec("DROP TABLE " + ftsTable); err != nil {
+      return err
+    }
+  }
+
+  log.Printf("building %s, this may take a while\n", ftsTable)
+  _, err = client.db.Exec(fmt.Sprintf(
+    "CREATE VIRTUAL TABLE %s USING fts5(accession_number UNINDEXED, %s)", ftsTable, columns))
+  if err != nil {
+    return err
+  }
+  // same filings as index_builder sends to elasticsearch, sections
+  // without a table in this database are left empty
+  available, err := config.AvailableSections(client.db)
+  if err != nil {
+    return err
+  }
+  var contents []string
+  for _, s := range config.Sections {
+    content := "''"
+    for _, a := range available {
+      if a.Table == s.Table {
+        content = fmt.Sprintf("coalesce(%s.contents, '')", s.Table)
+      }
+    }
+    contents = append(contents, content)
+  }
+  _, err = client.db.Exec(fmt.Sprintf(`
+    INSERT INTO %s (accession_number, %s)
+    SELECT filings.accession_number, %s
+    FROM filings %s
+    WHERE substr(filings.filed_date,1,4)>='2005'`,
+    ftsTable, columns, strings.Join(contents, ", "), config.SectionJoins(available)))
+  return err
+}
+
+// FTS5 match expression for the query within one section's column,
+// or within any one of them for all sections
+func ftsMatch(q *queryNode, section string) (string, error) {
+  if section == allSections {
+    var matches []string
+    for _, s := range config.Sections {
+      if !s.Derived {
+        matches = append(matches, fmt.Sprintf("(%s : (%s))", s.Table, q.ftsQuery()))
+      }
+    }
+    return strings.Join(matches, " OR "), nil
+  }
+  s, ok := config.FindSection(section)
+  if !ok {
+    return "", fmt.Errorf("unknown section '%s'", section)
+  }
+  return fmt.Sprintf("%s : (%s)", s.Table, q.ftsQuery()), nil
+}
+
+// snippet() columns of up to tokens words for the highlight query, one per
+// section searched, or highlight() columns of the whole sections
+func ftsSnippets(section string, whole bool, tokens int) ([]string, string) {
+  searched := []string{section}
+  if section == allSections {
+    searched = primarySections
+  }
+  var snippets []string
+  for _, name := range searched {
+    for i, s := range config.Sections {
+      if s.Name == name {
+        // accession_number is column 0
+        if whole {
+          snippets = append(snippets,
+            fmt.Sprintf("highlight(%s, %d, char(2), char(3))", ftsTable, i+1))
+        } else {
+          snippets = append(snippets,
+            fmt.Sprintf("snippet(%s, %d, char(2), char(3), '...', %d)", ftsTable, i+1, tokens))
+        }
+      }
+    }
+  }
+  return searched, strings.Join(snippets, ", ")
+}
+
+// snippet() words for about size characters, at least one and at most the
+// 64 fts5 allows
+func snippetTokens(size int) int {
+  return min(max(size/6, 1), 64)
+}
+
+// escape snippet text and turn the \x02 \x03 match markers into <em> tags
+// like the elasticsearch highlighter returns
+func snippetHTML(s string) template.HTML {
+  s = html.EscapeString(s)
+  s = strings.ReplaceAll(s, "\x02", "<em>")
+  s = strings.ReplaceAll(s, "\x03", "</em>")
+  return template.HTML(s)
+}
+
+func (client *SQLiteClient) histogramSearch(p *Parameters, searched []string) (
+  map[string](map[string]int), error) {
+
+  counts := make(map[string](map[string]int))
+
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return counts, err
+  }
+
+  for _, section := range searched {
+    match, err := ftsMatch(q, section)
+    if err != nil {
+      return counts, err
+    }
+    companies, companyArgs := client.companies(p)
+    m, err := client.yearCounts(fmt.Sprintf(sqliteHistogramQuery, sqliteCount(p.metric), companies),
+      append([]any{match}, companyArgs...)...)
+    if err != nil {
+      return counts, err
+    }
+    counts[section] = m
+  }
+  return counts, nil
+}
+
+// condition on the FTS table that a filing has the section, its column is
+// empty when it doesn't. every filing has at least one section
+func sqliteHasSection(section string) (string, error) {
+  if section == allSections {
+    return "1", nil
+  }
+  s, ok := config.FindSection(section)
+  if !ok {
+    return "", fmt.Errorf("unknown section '%s'", section)
+  }
+  return fmt.Sprintf("filings_fts.%s!=''", s.Table), nil
+}
+
+// condition and its arguments for the listed companies, or without a list
+// those in the stock index, and in the sector if one is picked. without
+// SIC codes no company is in a sector
+func (client *SQLiteClient) companies(p *Parameters) (string, []any) {
+  var (
+    where string
+    args []any
+  )
+  if len(p.tickers) == 0 {
+    where, args = "coalesce(companies.index_membership, '')=?", []any{p.stockIndex}
+  } else {
+    for _, ticker := range p.tickers {
+      args = append(args, ticker)
+    }
+    where = "companies.ticker IN (?" + strings.Repeat(", ?", len(p.tickers)-1) + ")"
+  }
+  if p.sector != "" {
+    if !client.hasSIC {
+      return "0", nil
+    }
+    where += " AND " + sqliteSector + "=?"
+    args = append(args, p.sector)
+  }
+  return where, args
+}
+
+// count of filings, or of companies filing for the companies metric
+func sqliteCount(metric string) string {
+  if metric == companiesMetric {
+    return "count(DISTINCT companies.ticker)"
+  }
+  return "count(*)"
+}
+
+func (client *SQLiteClient) filingCounts(p *Parameters, searched []string) (
+  map[string](map[string]int), error) {
+
+  counts := make(map[string](map[string]int))
+  for _, section := range searched {
+    hasSection, err := sqliteHasSection(section)
+    if err != nil {
+      return counts, err
+    }
+    companies, companyArgs := client.companies(p)
+    m, err := client.yearCounts(fmt.Sprintf(sqliteFilingsQuery, sqliteCount(p.metric), hasSection,
+      companies), companyArgs...)
+    if err != nil {
+      return counts, err
+    }
+    counts[section] = m
+  }
+  return counts, nil
+}
+
+func (client *SQLiteClient) sectorCounts(p *Parameters, all bool) (
+  map[string](map[string]int), error) {
+
+  counts := make(map[string](map[string]int))
+  if !client.hasSIC {
+    return counts, fmt.Errorf("no companies.%s column for sectors", config.SICColumn)
+  }
+
+  where := "1"
+  var args []any
+  if !all {
+    q, err := parseQuery(p.searchTerm)
+    if err != nil {
+      return counts, err
+    }
+    match, err := ftsMatch(q, allSections)
+    if err != nil {
+      return counts, err
+    }
+    where, args = "filings_fts MATCH ?", []any{match}
+  }
+  companies, companyArgs := client.companies(p)
+  rows, err := client.db.Query(fmt.Sprintf(sqliteSectorQuery, sqliteCount(p.metric), sqliteSector,
+    where, companies), append(args, companyArgs...)...)
+  if err != nil {
+    return counts, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var (
+      sector, year string
+      count int
+    )
+    if err = rows.Scan(&sector, &year, &count); err != nil {
+      return counts, err
+    }
+    if counts[sector] == nil {
+      counts[sector] = make(map[string]int)
+    }
+    counts[sector][year] = count
+  }
+  return counts, rows.Err()
+}
+
+func (client *SQLiteClient) topCompanies(p *Parameters, size int) ([]CompanyCount, error) {
+  var companies []CompanyCount
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return companies, err
+  }
+  match, err := ftsMatch(q, allSections)
+  if err != nil {
+    return companies, err
+  }
+  where, args := client.companies(p)
+  args = append(append([]any{match}, args...), size)
+  rows, err := client.db.Query(fmt.Sprintf(sqliteTopCompaniesQuery, where), args...)
+  if err != nil {
+    return companies, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var c CompanyCount
+    if err = rows.Scan(&c.Ticker, &c.Name, &c.Filings, &c.FirstYear, &c.LastYear); err != nil {
+      return companies, err
+    }
+    companies = append(companies, c)
+  }
+  return companies, rows.Err()
+}
+
+func (client *SQLiteClient) companyTimelines(p *Parameters) ([]CompanyTimeline, error) {
+  var timelines []CompanyTimeline
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return timelines, err
+  }
+  match, err := ftsMatch(q, p.section)
+  if err != nil {
+    return timelines, err
+  }
+  where, args := client.companies(p)
+  rows, err := client.db.Query(fmt.Sprintf(sqliteTimelineQuery, where),
+    append([]any{match}, args...)...)
+  if err != nil {
+    return timelines, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var (
+      ticker, name, year string
+      count int
+    )
+    if err = rows.Scan(&ticker, &name, &year, &count); err != nil {
+      return timelines, err
+    }
+    timelines = addTimelineYear(timelines, ticker, name, year, count)
+  }
+  if err = rows.Err(); err != nil {
+    return timelines, err
+  }
+  sortTimelines(timelines)
+  return timelines, nil
+}
+
+func (client *SQLiteClient) filingSection(ticker, section string, year int) (
+  *FilingSection, error) {
+
+  s, ok := config.FindSection(section)
+  if !ok {
+    return nil, fmt.Errorf("unknown section '%s'", section)
+  }
+  hasSection, err := sqliteHasSection(section)
+  if err != nil {
+    return nil, err
+  }
+  f := FilingSection{Section: section}
+  err = client.db.QueryRow(fmt.Sprintf(sqliteFilingSectionQuery, s.Table, hasSection),
+    ticker, strconv.Itoa(year)).Scan(&f.Id, &f.Ticker, &f.Name, &f.Filed, &f.Url, &f.Text)
+  if err == sql.ErrNoRows {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &f, nil
+}
+
+func (client *SQLiteClient) filing(id string, q *queryNode) (*Filing, error) {
+  if q != nil {
+    match, err := ftsMatch(q, allSections)
+    if err != nil {
+      return nil, err
+    }
+    f, err := client.filingRow(id, q, match)
+    if f != nil || err != nil {
+      return f, err
+    }
+  }
+  // a filing without matches isn't found by the match
+  return client.filingRow(id, q, "")
+}
+
+// the filing with each section's column highlighted by the match, or plain
+// without one, nil if not found
+func (client *SQLiteClient) filingRow(id string, q *queryNode, match string) (*Filing, error) {
+  var columns []string
+  for i, s := range config.Sections {
+    if match != "" {
+      // accession_number is column 0
+      columns = append(columns, fmt.Sprintf("highlight(%s, %d, char(2), char(3))", ftsTable, i+1))
+    } else {
+      columns = append(columns, "filings_fts." + s.Table)
+    }
+  }
+  where, args := "1", []any{id}
+  if match != "" {
+    where, args = "filings_fts MATCH ?", append(args, match)
+  }
+
+  var f Filing
+  texts := make([]string, len(config.Sections))
+  dest := []any{&f.Id, &f.Ticker, &f.Name, &f.Filed, &f.Url}
+  for i := range texts {
+    dest = append(dest, &texts[i])
+  }
+  err := client.db.QueryRow(fmt.Sprintf(sqliteFilingQuery, strings.Join(columns, ",\n    "), where),
+    args...).Scan(dest...)
+  if err == sql.ErrNoRows {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  // sections the filing doesn't have are empty
+  for i, s := range config.Sections {
+    if texts[i] != "" && !s.Derived {
+      f.Sections = append(f.Sections, newFilingText(s.Name, snippetHTML(texts[i]), q))
+    }
+  }
+  return &f, nil
+}
+
+// counts per year from a query selecting year, count
+func (client *SQLiteClient) yearCounts(query string, args ...any) (map[string]int, error) {
+  m := make(map[string]int)
+  rows, err := client.db.Query(query, args...)
+  if err != nil {
+    return m, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var (
+      year string
+      count int
+    )
+    if err = rows.Scan(&year, &count); err != nil {
+      return m, err
+    }
+    m[year] = count
+  }
+  return m, rows.Err()
+}
+
+func (client *SQLiteClient) highlightSearch(p *Parameters, size int) (
+  int, []Hit, error) {
+
+  var (
+    total = 0
+    hits []Hit
+  )
+
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return total, hits, err
+  }
+  match, err := ftsMatch(q, p.section)
+  if err != nil {
+    return total, hits, err
+  }
+  filedLower, filedUpper, err := p.filedRange()
+  if err != nil {
+    return total, hits, err
+  }
+
+  companies, companyArgs := client.companies(p)
+  args := append(append([]any{match}, companyArgs...), filedLower, filedUpper)
+  err = client.db.QueryRow(fmt.Sprintf(sqliteCountQuery, companies), args...).Scan(&total)
+  if err != nil {
+    return total, hits, err
+  }
+
+  cursor, err := decodeCursor(p.cursor)
+  if err != nil {
+    return total, hits, err
+  }
+  var after []string
+  if cursor != nil {
+    if after, err = sqliteCursorAfter(cursor); err != nil {
+      return total, hits, err
+    }
+  }
+
+  offset := (p.page-1) * size
+  if after != nil {
+    offset = 0
+  }
+  err = client.highlightRows(q, match, p, after, size, offset, func(hit Hit) error {
+    hits = append(hits, hit)
+    return nil
+  })
+  if len(hits) > 0 {
+    last := &hits[len(hits)-1]
+    filed, _ := json.Marshal(last.Filed)
+    id, _ := json.Marshal(last.Id)
+    last.cursor = (&Cursor{After: []json.RawMessage{filed, id}}).encode()
+  }
+  return total, hits, err
+}
+
+// filed date and accession number the cursor resumes after
+func sqliteCursorAfter(c *Cursor) ([]string, error) {
+  after := make([]string, 2)
+  if len(c.After) != len(after) {
+    return nil, fmt.Errorf("cursor has %d search after values, expected 2", len(c.After))
+  }
+  for i := range after {
+    if err := json.Unmarshal(c.After[i], &after[i]); err != nil {
+      return nil, err
+    }
+  }
+  return after, nil
+}
+
+// call fn with each highlighted hit in a page of the highlight query,
+// starting after the filed date and accession number in after if set.
+// a negative limit is every hit. snippet() makes one excerpt of about
+// p.fragmentSize characters per section, more are cut from the whole
+// sections like the matches are counted in
+func (client *SQLiteClient) highlightRows(q *queryNode, match string, p *Parameters,
+  after []string, limit, offset int, fn func(Hit) error) error {
+
+  filedLower, filedUpper, err := p.filedRange()
+  if err != nil {
+    return err
+  }
+  whole := p.occurrences || p.fragments > 1
+
+  searched, snippets := ftsSnippets(p.section, whole, snippetTokens(p.fragmentSize))
+  companies, companyArgs := client.companies(p)
+  args := append(append([]any{match}, companyArgs...), filedLower, filedUpper)
+  afterWhere := ""
+  if after != nil {
+    afterWhere = sqliteAfter
+    args = append(args, after[0], after[1])
+  }
+  args = append(args, limit, offset)
+  rows, err := client.db.Query(fmt.Sprintf(sqliteHighlightQuery, snippets, companies, afterWhere),
+    args...)
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    var hit Hit
+    snippetValues := make([]string, len(searched))
+    dest := []any{&hit.Id, &hit.Score, &hit.Filed, &hit.Ticker, &hit.Name, &hit.Url}
+    for i := range snippetValues {
+      dest = append(dest, &snippetValues[i])
+    }
+    if err = rows.Scan(dest...); err != nil {
+      return err
+    }
+
+    excerpts := make(map[string]template.HTML)
+    for i, s := range searched {
+      excerpts[s] = q.mergeNearHighlights(snippetHTML(snippetValues[i]))
+    }
+    section, excerpt := bestExcerpt(excerpts)
+    hit.Section = section
+    hit.Excerpt, hit.Excerpts = excerpt, []template.HTML{excerpt}
+    if whole {
+      hit.cutExcerpts(p.fragments, p.fragmentSize)
+    }
+    if p.occurrences {
+      hit.SectionOccurrences = occurrences(excerpts)
+    }
+    if err = fn(hit); err != nil {
+      return err
+    }
+  }
+  return rows.Err()
+}
+
+func (client *SQLiteClient) exportHits(p *Parameters, fn func(Hit) error) error {
+  q, err := parseQuery(p.searchTerm)
+  if err != nil {
+    return err
+  }
+  match, err := ftsMatch(q, p.section)
+  if err != nil {
+    return err
+  }
+  // the table's excerpts, matches aren't counted
+  fragments := *p
+  fragments.occurrences = false
+  return client.highlightRows(q, match, &fragments, nil, -1, 0, fn)
+}
+
+func (client *SQLiteClient) facets() (*Facets, error) {
+  var first, last string
+  err := client.db.QueryRow(`
+    SELECT coalesce(min(filings.filed_date), ''), coalesce(max(filings.filed_date), '')
+    FROM filings_fts
+    JOIN filings ON filings.accession_number=filings_fts.accession_number`).Scan(&first, &last)
+  if err != nil {
+    return nil, err
+  }
+
+  stockIndices, err := client.distinct("coalesce(companies.index_membership, '')")
+  if err != nil {
+    return nil, err
+  }
+  var sectors []string
+  if client.hasSIC {
+    if sectors, err = client.distinct(sqliteSector); err != nil {
+      return nil, err
+    }
+  }
+  return newFacets(first, last, stockIndices, sectors)
+}
+
+// distinct values of an expression on the companies table
+func (client *SQLiteClient) distinct(expr string) ([]string, error) {
+  var values []string
+  rows, err := client.db.Query(fmt.Sprintf("SELECT DISTINCT %s FROM companies ORDER BY 1", expr))
+  if err != nil {
+    return values, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var s string
+    if err = rows.Scan(&s); err != nil {
+      return values, err
+    }
+    values = append(values, s)
+  }
+  return values, rows.Err()
+}
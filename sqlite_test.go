@@ -0,0 +1,293 @@
+//go:build sqlite_fts5
+
+// tests for the sqlite search backend, run with go test -tags sqlite_fts5
+package main
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+  "path/filepath"
+  "html/template"
+  "database/sql"
+  "github.com/kyleleelarson/sec-search/config"
+)
+
+// minimal copy of the index_builder source schema
+const testSchema = `
+  CREATE TABLE companies (ticker TEXT PRIMARY KEY, name TEXT, index_membership TEXT, sic_code TEXT);
+  CREATE TABLE filings (accession_number TEXT PRIMARY KEY, ticker TEXT, filed_date TEXT, link_10k TEXT);
+  CREATE TABLE item1 (accession_number TEXT PRIMARY KEY, contents TEXT);
+  CREATE TABLE item1a (accession_number TEXT PRIMARY KEY, contents TEXT);
+  INSERT INTO companies VALUES ('ABC', 'Abc Inc', 'S&P 500', '7372'),
+    ('XYZ', 'Xyz Corp', 'Russell 2000', '3571');
+  INSERT INTO filings VALUES
+    ('1', 'ABC', '2012-02-28', 'https://sec.gov/1'),
+    ('2', 'ABC', '2013-02-27', 'https://sec.gov/2'),
+    ('3', 'XYZ', '2013-03-01', 'https://sec.gov/3'),
+    ('4', 'ABC', '2004-03-01', 'https://sec.gov/4'),
+    ('5', 'ABC', '2013-06-03', 'https://sec.gov/5');
+  INSERT INTO item1 VALUES
+    ('1', 'We sell cloud computing <services>.'),
+    ('2', 'We sell cloud computing and storage.'),
+    ('3', 'We sell cloud computing too.'),
+    ('4', 'We sold cloud computing early.'),
+    ('5', 'Amended, we sell disks.');
+  INSERT INTO item1a VALUES ('2', 'An outage of our cloud computing platform would hurt us.');
+  CREATE TABLE item1a_new (accession_number TEXT PRIMARY KEY, contents TEXT);
+  INSERT INTO item1a_new VALUES ('2', 'Tariffs may raise our costs.');`
+
+func newTestSQLiteClient(t *testing.T) *SQLiteClient {
+  path := filepath.Join(t.TempDir(), "filings.sqlite3")
+  db, err := sql.Open("sqlite3", path)
+  if err != nil {
+    t.Fatalf("open error: %s.", err)
+  }
+  if _, err = db.Exec(testSchema); err != nil {
+    t.Fatalf("schema error: %s.", err)
+  }
+  db.Close()
+
+  t.Setenv("SQLITE_PATH", path)
+  return NewSQLiteClient()
+}
+
+func TestSQLiteSearch(t *testing.T) {
+  client := newTestSQLiteClient(t)
+
+  counts, err := client.histogramSearch(&Parameters{searchTerm: "cloud computing",
+    stockIndex: "S&P 500"}, sections)
+  if err != nil {
+    t.Fatalf("histogramSearch error: %s.", err)
+  }
+  if counts[sections[0]]["2012"] != 1 || counts[sections[0]]["2013"] != 1 ||
+     counts[sections[1]]["2013"] != 1 || len(counts[sections[0]]) != 2 {
+    t.Fatalf("counts %v, expected one filing per year, 2004 and Russell 2000 excluded.", counts)
+  }
+
+  counts, err = client.histogramSearch(&Parameters{searchTerm: "outage OR services",
+    stockIndex: "S&P 500"}, []string{allSections})
+  if err != nil || counts[allSections]["2012"] != 1 || counts[allSections]["2013"] != 1 {
+    t.Fatalf("counts %v, error %v, expected a filing a year in any section.", counts, err)
+  }
+
+  // every ABC filing has item 1 but only one has item 1A
+  filings, err := client.filingCounts(&Parameters{stockIndex: "S&P 500"},
+    []string{sections[0], sections[1], allSections})
+  if err != nil || filings[sections[0]]["2013"] != 2 || filings[sections[0]]["2004"] != 0 ||
+     filings[sections[1]]["2012"] != 0 || filings[sections[1]]["2013"] != 1 ||
+     filings[allSections]["2012"] != 1 {
+    t.Fatalf("filings %v, error %v, expected counts of filings with each section.", filings, err)
+  }
+
+  // ABC filed twice in 2013
+  p := Parameters{searchTerm: "disks OR storage", stockIndex: "S&P 500", metric: companiesMetric}
+  counts, err = client.histogramSearch(&p, sections)
+  if err != nil || counts[sections[0]]["2013"] != 1 {
+    t.Fatalf("counts %v, error %v, expected one company in 2013.", counts, err)
+  }
+  filings, err = client.filingCounts(&p, sections)
+  if err != nil || filings[sections[0]]["2013"] != 1 {
+    t.Fatalf("filings %v, error %v, expected one company in 2013.", filings, err)
+  }
+  p.metric = filingsMetric
+  counts, err = client.histogramSearch(&p, sections)
+  if err != nil || counts[sections[0]]["2013"] != 2 {
+    t.Fatalf("counts %v, error %v, expected two filings in 2013.", counts, err)
+  }
+
+  total, hits, err := client.highlightSearch(&Parameters{searchTerm: "cloud computing",
+    stockIndex: "S&P 500", section: sections[0], year: "2012", page: 1,
+    fragments: 1, fragmentSize: 200}, 10)
+  if err != nil {
+    t.Fatalf("highlightSearch error: %s.", err)
+  }
+  if total != 1 || len(hits) != 1 || hits[0].Ticker != "ABC" {
+    t.Fatalf("total %d, hits %v, expected the 2012 ABC filing.", total, hits)
+  }
+  total, _, err = client.highlightSearch(&Parameters{searchTerm: "cloud NOT (storage OR services)",
+    stockIndex: "S&P 500", section: sections[0], year: "2013", page: 1,
+    fragments: 1, fragmentSize: 200}, 10)
+  if err != nil || total != 0 {
+    t.Fatalf("total %d, error %v, expected no 2013 hits without storage.", total, err)
+  }
+
+  excerpt := string(hits[0].Excerpt)
+  if !strings.Contains(excerpt, "<em>cloud computing</em>") ||
+     !strings.Contains(excerpt, "&lt;services&gt;") {
+    t.Fatalf("excerpt %s, expected highlighted and escaped text.", excerpt)
+  }
+
+  _, hits, err = client.highlightSearch(&Parameters{searchTerm: "outage NEAR/3 computing",
+    stockIndex: "S&P 500", section: sections[1], year: "2013", page: 1,
+    fragments: 1, fragmentSize: 200}, 10)
+  if err != nil || len(hits) != 1 ||
+     hits[0].Excerpt != template.HTML("An <em>outage of our cloud computing</em> platform would hurt us.") {
+    t.Fatalf("hits %v, error %v, expected whole NEAR span highlighted.", hits, err)
+  }
+
+  total, hits, err = client.highlightSearch(&Parameters{searchTerm: "outage OR platform OR storage",
+    stockIndex: "S&P 500", section: allSections, year: "2013", page: 1,
+    fragments: 1, fragmentSize: 200}, 10)
+  if err != nil || total != 1 || hits[0].Section != sections[1] {
+    t.Fatalf("total %d, hits %v, error %v, expected one hit with the risk factors excerpt.",
+      total, hits, err)
+  }
+
+  // matches counted in every section searched when asked
+  p = Parameters{searchTerm: "cloud", stockIndex: "S&P 500", section: allSections,
+                 year: "2013", page: 1, fragments: 3, fragmentSize: 200}
+  _, hits, err = client.highlightSearch(&p, 10)
+  if err != nil || len(hits) != 1 || hits[0].SectionOccurrences != 0 || len(hits[0].Excerpts) != 1 {
+    t.Fatalf("hits %v, error %v, expected one excerpt and no count.", hits, err)
+  }
+  p.occurrences = true
+  _, hits, err = client.highlightSearch(&p, 10)
+  if err != nil || len(hits) != 1 || hits[0].SectionOccurrences != 2 || len(hits[0].Excerpts) != 1 {
+    t.Fatalf("hits %v, error %v, expected two occurrences and one excerpt.", hits, err)
+  }
+
+  total, _, err = client.highlightSearch(&Parameters{searchTerm: "cloud computing",
+    stockIndex: "S&P 500", section: sections[0], from: "2012-02-28", to: "2013-02-27", page: 1,
+    fragments: 1, fragmentSize: 200}, 10)
+  if err != nil || total != 2 {
+    t.Fatalf("total %d, error %v, expected both ABC filings in range.", total, err)
+  }
+
+  var exported []Hit
+  err = client.exportHits(&Parameters{searchTerm: "cloud computing", stockIndex: "S&P 500",
+    section: sections[0], from: "2005", page: 1, fragments: 2, fragmentSize: 200,
+    occurrences: true}, func(hit Hit) error {
+    exported = append(exported, hit)
+    return nil
+  })
+  if err != nil || len(exported) != 2 || exported[0].Filed != "2013-02-27" ||
+     exported[1].Filed != "2012-02-28" {
+    t.Fatalf("exported %v, error %v, expected both ABC filings newest first.", exported, err)
+  }
+  // with the table's excerpts, cut from the whole sections, and no count
+  for _, hit := range exported {
+    if hit.SectionOccurrences != 0 || len(hit.Excerpts) == 0 || hit.Excerpt != hit.Excerpts[0] ||
+       !strings.Contains(string(hit.Excerpt), "<em>") {
+      t.Fatalf("exported %v, expected highlighted excerpts and no count.", hit)
+    }
+  }
+
+  // page through both with a cursor
+  p = Parameters{searchTerm: "cloud computing", stockIndex: "S&P 500", section: sections[0],
+                  from: "2005", page: 1, fragments: 1, fragmentSize: 200}
+  _, first, err := client.highlightSearch(&p, 1)
+  if err != nil || len(first) != 1 || first[0].cursor == "" {
+    t.Fatalf("hits %v, error %v, expected one hit with a cursor.", first, err)
+  }
+  p.page, p.cursor = 2, first[0].cursor
+  total, second, err := client.highlightSearch(&p, 1)
+  if err != nil || total != 2 || len(second) != 1 || second[0].Filed != "2012-02-28" {
+    t.Fatalf("total %d, hits %v, error %v, expected the 2012 filing after the cursor.",
+      total, second, err)
+  }
+
+  // tickers in place of the stock index, XYZ isn't in the S&P 500
+  p = Parameters{searchTerm: "cloud computing", stockIndex: "S&P 500", tickers: []string{"XYZ", "ABC"},
+                 section: sections[0], from: "2013", to: "2013", page: 1,
+                 fragments: 1, fragmentSize: 200}
+  total, hits, err = client.highlightSearch(&p, 10)
+  if err != nil || total != 2 || len(hits) != 2 {
+    t.Fatalf("total %d, hits %v, error %v, expected ABC and XYZ 2013 filings.", total, hits, err)
+  }
+  counts, err = client.histogramSearch(&p, sections)
+  if err != nil || counts[sections[0]]["2013"] != 2 || counts[sections[0]]["2012"] != 1 {
+    t.Fatalf("counts %v, error %v, expected both companies counted.", counts, err)
+  }
+
+  // sectors from SIC codes, software is services and computers manufacturing
+  p.sector = "Services"
+  if total, hits, err = client.highlightSearch(&p, 10); err != nil || total != 1 ||
+     hits[0].Ticker != "ABC" {
+    t.Fatalf("total %d, hits %v, error %v, expected the ABC filing.", total, hits, err)
+  }
+  p.sector = ""
+  counts, err = client.sectorCounts(&p, false)
+  if err != nil || counts["Services"]["2013"] != 1 || counts["Manufacturing"]["2013"] != 1 ||
+     counts["Services"]["2012"] != 1 || len(counts) != 2 {
+    t.Fatalf("counts %v, error %v, expected matches per sector.", counts, err)
+  }
+  counts, err = client.sectorCounts(&p, true)
+  if err != nil || counts["Services"]["2013"] != 2 || counts["Services"]["2012"] != 1 ||
+     counts["Manufacturing"]["2013"] != 1 {
+    t.Fatalf("counts %v, error %v, expected every filing per sector.", counts, err)
+  }
+  // ABC's three 2012 and 2013 filings before XYZ's one
+  top, err := client.topCompanies(&Parameters{searchTerm: "sell", tickers: []string{"ABC", "XYZ"}}, 5)
+  expectedTop := []CompanyCount{
+    {Ticker: "ABC", Name: "Abc Inc", Filings: 3, FirstYear: "2012", LastYear: "2013"},
+    {Ticker: "XYZ", Name: "Xyz Corp", Filings: 1, FirstYear: "2013", LastYear: "2013"},
+  }
+  if err != nil || !reflect.DeepEqual(top, expectedTop) {
+    t.Fatalf("top %v, error %v, expected %v.", top, err, expectedTop)
+  }
+
+  // first and latest years with cloud in item 1
+  timelines, err := client.companyTimelines(&Parameters{searchTerm: "cloud",
+    tickers: []string{"ABC", "XYZ"}, section: sections[0]})
+  if err != nil || len(timelines) != 2 || timelines[0].Ticker != "ABC" ||
+     timelines[0].FirstYear != "2012" || timelines[0].LastYear != "2013" ||
+     timelines[0].Years["2013"] != 1 || timelines[1].Ticker != "XYZ" || timelines[1].Filings != 1 {
+    t.Fatalf("timelines %v, error %v, expected ABC from 2012 then XYZ.", timelines, err)
+  }
+
+  // the later of ABC's 2013 filings with item 1, and only one has item 1A
+  filing, err := client.filingSection("ABC", sections[0], 2013)
+  if err != nil || filing == nil || filing.Id != "5" || filing.Text != "Amended, we sell disks." ||
+     filing.Name != "Abc Inc" || filing.Url != "https://sec.gov/5" {
+    t.Fatalf("filing %v, error %v, expected ABC's amended 2013 filing.", filing, err)
+  }
+  filing, err = client.filingSection("ABC", sections[1], 2013)
+  if err != nil || filing == nil || filing.Id != "2" {
+    t.Fatalf("filing %v, error %v, expected ABC's 2013 filing with item 1A.", filing, err)
+  }
+  if filing, err = client.filingSection("ABC", sections[1], 2012); err != nil || filing != nil {
+    t.Fatalf("filing %v, error %v, expected none.", filing, err)
+  }
+
+  // new risk factors are searched like any section
+  p = Parameters{searchTerm: "tariffs OR outage", stockIndex: "S&P 500",
+                 section: config.Sections[2].Name, from: "2013", to: "2013", page: 1,
+                 fragments: 1, fragmentSize: 200}
+  total, hits, err = client.highlightSearch(&p, 10)
+  if err != nil || total != 1 || hits[0].Id != "2" ||
+     hits[0].Excerpt != "<em>Tariffs</em> may raise our costs." {
+    t.Fatalf("total %d, hits %v, error %v, expected only the new risk factor.", total, hits, err)
+  }
+  // but only on their own, they repeat the risk factors
+  p.searchTerm, p.section = "tariffs", allSections
+  if total, _, err = client.highlightSearch(&p, 10); err != nil || total != 0 {
+    t.Fatalf("total %d, error %v, expected no hits in all sections.", total, err)
+  }
+
+  // every match in each section, or the sections as they are. new risk
+  // factors are already in the risk factors
+  q, _ := parseQuery("cloud")
+  doc, err := client.filing("2", q)
+  if err != nil || doc == nil || len(doc.Sections) != 2 || doc.Ticker != "ABC" ||
+     doc.Sections[0].Text != "We sell <em>cloud</em> computing and storage." ||
+     doc.Sections[1].Matches != 1 {
+    t.Fatalf("filing %v, error %v, expected two sections with cloud highlighted.", doc, err)
+  }
+  doc, err = client.filing("1", nil)
+  if err != nil || doc == nil || len(doc.Sections) != 1 ||
+     doc.Sections[0].Text != "We sell cloud computing &lt;services&gt;." {
+    t.Fatalf("filing %v, error %v, expected item 1 escaped.", doc, err)
+  }
+  if doc, err = client.filing("5", q); err != nil || doc == nil || doc.Sections[0].Matches != 0 {
+    t.Fatalf("filing %v, error %v, expected the filing without matches.", doc, err)
+  }
+  if doc, err = client.filing("9", nil); err != nil || doc != nil {
+    t.Fatalf("filing %v, error %v, expected none.", doc, err)
+  }
+
+  f, err := client.facets()
+  if err != nil || strings.Join(f.Sectors, ",") != "Manufacturing,Services" {
+    t.Fatalf("facets %v, error %v, expected both sectors.", f, err)
+  }
+}
@@ -0,0 +1,174 @@
+// paragraph level diffs of section text, for comparing a company's filings
+package textdiff
+
+import (
+  "strings"
+  "unicode"
+)
+
+type Op int
+
+const (
+  Equal Op = iota
+  Delete   // only in the old text
+  Insert   // only in the revised text
+)
+
+// equal, delete or insert
+func (op Op) String() string {
+  switch op {
+  case Delete:
+    return "delete"
+  case Insert:
+    return "insert"
+  }
+  return "equal"
+}
+
+// a paragraph and whether it was kept, removed or added
+type Edit struct {
+  Op   Op
+  Text string
+}
+
+// non-blank lines of the text, with runs of white space collapsed
+func Paragraphs(text string) []string {
+  var paragraphs []string
+  for _, line := range strings.Split(text, "\n") {
+    if p := strings.Join(strings.Fields(line), " "); p != "" {
+      paragraphs = append(paragraphs, p)
+    }
+  }
+  return paragraphs
+}
+
+// edits turning old into revised, from the longest common subsequence of
+// paragraphs. removed paragraphs come before those added in their place
+func Diff(old, revised []string) []Edit {
+  // lcs[i][j] is the longest common subsequence of old[i:] and revised[j:]
+  lcs := make([][]int, len(old)+1)
+  for i := range lcs {
+    lcs[i] = make([]int, len(revised)+1)
+  }
+  for i := len(old)-1; i >= 0; i-- {
+    for j := len(revised)-1; j >= 0; j-- {
+      if old[i] == revised[j] {
+        lcs[i][j] = lcs[i+1][j+1] + 1
+      } else {
+        lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+      }
+    }
+  }
+
+  var edits []Edit
+  i, j := 0, 0
+  for i < len(old) && j < len(revised) {
+    switch {
+    case old[i] == revised[j]:
+      edits = append(edits, Edit{Equal, old[i]})
+      i++
+      j++
+    case lcs[i+1][j] >= lcs[i][j+1]:
+      edits = append(edits, Edit{Delete, old[i]})
+      i++
+    default:
+      edits = append(edits, Edit{Insert, revised[j]})
+      j++
+    }
+  }
+  for ; i < len(old); i++ {
+    edits = append(edits, Edit{Delete, old[i]})
+  }
+  for ; j < len(revised); j++ {
+    edits = append(edits, Edit{Insert, revised[j]})
+  }
+  return edits
+}
+
+// paragraphs removed and added
+func Count(edits []Edit) (int, int) {
+  var deleted, inserted int
+  for _, e := range edits {
+    switch e.Op {
+    case Delete:
+      deleted++
+    case Insert:
+      inserted++
+    }
+  }
+  return deleted, inserted
+}
+
+// lower case words of a paragraph, without punctuation
+func words(paragraph string) map[string]bool {
+  set := make(map[string]bool)
+  for _, w := range strings.FieldsFunc(strings.ToLower(paragraph), func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+  }) {
+    set[w] = true
+  }
+  return set
+}
+
+// words in both sets over words in either, 1 for two empty sets
+func jaccard(a, b map[string]bool) float64 {
+  if len(a) == 0 && len(b) == 0 {
+    return 1
+  }
+  if len(a) > len(b) {
+    a, b = b, a
+  }
+  common := 0
+  for w := range a {
+    if b[w] {
+      common++
+    }
+  }
+  return float64(common) / float64(len(a) + len(b) - common)
+}
+
+// share of the words in either paragraph that are in both, ignoring case,
+// punctuation and word order
+func Similarity(a, b string) float64 {
+  return jaccard(words(a), words(b))
+}
+
+// paragraphs of revised with no paragraph in old at least threshold
+// similar, in order. reworded paragraphs are not added
+func Added(old, revised []string, threshold float64) []string {
+  seen := make(map[string]bool)
+  for _, p := range old {
+    seen[p] = true
+  }
+  var oldWords []map[string]bool
+  for _, p := range old {
+    oldWords = append(oldWords, words(p))
+  }
+
+  var added []string
+  for _, p := range revised {
+    if seen[p] {
+      continue
+    }
+    w := words(p)
+    similar := false
+    for _, o := range oldWords {
+      // the smaller set over the larger bounds the similarity
+      small, large := len(w), len(o)
+      if small > large {
+        small, large = large, small
+      }
+      if large > 0 && float64(small) / float64(large) < threshold {
+        continue
+      }
+      if jaccard(w, o) >= threshold {
+        similar = true
+        break
+      }
+    }
+    if !similar {
+      added = append(added, p)
+    }
+  }
+  return added
+}
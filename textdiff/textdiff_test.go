@@ -0,0 +1,62 @@
+// unittests for the paragraph diffs
+package textdiff
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestParagraphs(t *testing.T) {
+  paragraphs := Paragraphs("Risk  one.\n\n  \n\tRisk two\n continues.\n")
+  expected := []string{"Risk one.", "Risk two", "continues."}
+  if !reflect.DeepEqual(paragraphs, expected) {
+    t.Fatalf("paragraphs %q, expected %q.", paragraphs, expected)
+  }
+}
+
+func TestDiff(t *testing.T) {
+  old := []string{"Competition.", "Supply chain.", "Pandemic.", "Regulation."}
+  revised := []string{"Competition.", "Supply chain.", "Generative AI.", "Regulation.", "Tariffs."}
+  edits := Diff(old, revised)
+  expected := []Edit{
+    {Equal, "Competition."}, {Equal, "Supply chain."}, {Delete, "Pandemic."},
+    {Insert, "Generative AI."}, {Equal, "Regulation."}, {Insert, "Tariffs."},
+  }
+  if !reflect.DeepEqual(edits, expected) {
+    t.Fatalf("edits %v, expected %v.", edits, expected)
+  }
+  if deleted, inserted := Count(edits); deleted != 1 || inserted != 2 {
+    t.Fatalf("%d removed %d added, expected 1 and 2.", deleted, inserted)
+  }
+
+  if edits = Diff(nil, []string{"New."}); !reflect.DeepEqual(edits, []Edit{{Insert, "New."}}) {
+    t.Fatalf("edits %v, expected everything added.", edits)
+  }
+}
+
+func TestAdded(t *testing.T) {
+  if s := Similarity("We rely on suppliers.", "we rely on SUPPLIERS"); s != 1 {
+    t.Fatalf("similarity %v, expected 1 ignoring case and punctuation.", s)
+  }
+  if s := Similarity("a b c d", "a b c e"); s != 0.6 {
+    t.Fatalf("similarity %v, expected 3 of 5 words.", s)
+  }
+
+  old := []string{
+    "We face intense competition in all of our markets from larger companies.",
+    "Our business depends on a small number of suppliers for key components.",
+  }
+  revised := []string{
+    "We face intense competition in all of our markets from larger companies.",
+    // reworded
+    "Our business depends on a small number of suppliers for our key components.",
+    "Generative AI may disrupt our business.",
+  }
+  added := Added(old, revised, 0.8)
+  if !reflect.DeepEqual(added, []string{"Generative AI may disrupt our business."}) {
+    t.Fatalf("added %q, expected only the new risk.", added)
+  }
+  if added = Added(nil, revised[:1], 0.8); len(added) != 1 {
+    t.Fatalf("added %q, expected everything without an old filing.", added)
+  }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+  "log"
+  "sort"
+  "bytes"
+  "fmt"
+  "net/http"
+)
+
+// when each company first and last filed mentioning a search term, and
+// the years in between it did, over every year in the index
+
+// the years a company filed matching a search term
+type CompanyTimeline struct {
+  Ticker    string         `json:"ticker"`
+  Name      string         `json:"name"`
+  FirstYear string         `json:"first_year"`
+  LastYear  string         `json:"last_year"`
+  Filings   int            `json:"filings"`
+  Years     map[string]int `json:"years"` // matching filings per year
+}
+
+type APITimeline struct {
+  SearchTerm string            `json:"search_term"`
+  StockIndex string            `json:"stock_index"`
+  Section    string            `json:"section"`
+  Companies  []CompanyTimeline `json:"companies"`
+}
+
+// a table row, with a cell per year in the index
+type TimelineRow struct {
+  CompanyTimeline
+  Url      string
+  Presence []int // matching filings per year, zero for none
+}
+
+type TimelineData struct {
+  SearchTerm string
+  Section    string
+  Years      []string
+  Rows       []TimelineRow
+}
+
+// add a year's count from rows ordered by ticker, to the last timeline if
+// it is the same company
+func addTimelineYear(timelines []CompanyTimeline, ticker, name, year string,
+  count int) []CompanyTimeline {
+
+  if len(timelines) == 0 || timelines[len(timelines)-1].Ticker != ticker {
+    timelines = append(timelines, CompanyTimeline{Ticker: ticker, Name: name,
+      FirstYear: year, LastYear: year, Years: make(map[string]int)})
+  }
+  t := &timelines[len(timelines)-1]
+  if year < t.FirstYear {
+    t.FirstYear = year
+  }
+  if year > t.LastYear {
+    t.LastYear = year
+  }
+  t.Years[year] += count
+  t.Filings += count
+  return timelines
+}
+
+// earliest first mention first, then by ticker
+func sortTimelines(timelines []CompanyTimeline) {
+  sort.SliceStable(timelines, func(i, j int) bool {
+    if timelines[i].FirstYear != timelines[j].FirstYear {
+      return timelines[i].FirstYear < timelines[j].FirstYear
+    }
+    return timelines[i].Ticker < timelines[j].Ticker
+  })
+}
+
+func timelineHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
+  var buf bytes.Buffer
+
+  timelines, err := searcher.companyTimelines(p)
+  if err != nil {
+    http.Error(w, "timeline search error", http.StatusInternalServerError)
+    log.Printf("in timelineHandler with search term '%s', timeline search error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+
+  data := TimelineData{SearchTerm: p.searchTerm, Section: p.section,
+                       Years: currentFacets().years()}
+  for _, t := range timelines {
+    row := TimelineRow{CompanyTimeline: t, Url: companyURL(p, CompanyCount{Ticker: t.Ticker,
+      FirstYear: t.FirstYear, LastYear: t.LastYear})}
+    for _, year := range data.Years {
+      row.Presence = append(row.Presence, t.Years[year]) // zero if not in map
+    }
+    data.Rows = append(data.Rows, row)
+  }
+
+  err = templates.ExecuteTemplate(&buf, "timeline.html", data)
+  if err != nil {
+    http.Error(w, "template error", http.StatusInternalServerError)
+    log.Printf("in timelineHandler with search term '%s', execute template error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+
+  fmt.Fprintf(w, "%s", buf.String())
+}
+
+func apiTimelineHandler(w http.ResponseWriter, r *http.Request, p *Parameters) {
+  timelines, err := searcher.companyTimelines(p)
+  if err != nil {
+    writeJSONError(w, http.StatusInternalServerError, "timeline search error")
+    log.Printf("in apiTimelineHandler with search term '%s', timeline search error: %s\n",
+      p.searchTerm, err.Error())
+    return
+  }
+  if timelines == nil {
+    timelines = []CompanyTimeline{} // [] rather than null
+  }
+
+  writeJSON(w, http.StatusOK, APITimeline{
+    SearchTerm: p.searchTerm,
+    StockIndex: p.stockIndex,
+    Section:    p.section,
+    Companies:  timelines,
+  })
+}
@@ -0,0 +1,63 @@
+// unittests for the company timelines
+package main
+
+import (
+  "strconv"
+  "strings"
+  "testing"
+  "net/http"
+  "encoding/json"
+  "net/http/httptest"
+)
+
+// test merging company years and ordering by first mention
+func TestAddTimelineYear(t *testing.T) {
+  var timelines []CompanyTimeline
+  timelines = addTimelineYear(timelines, "ABC", "Abc Inc", "2015", 1)
+  timelines = addTimelineYear(timelines, "ABC", "Abc Inc", "2019", 2)
+  // renamed, the new name's years sort after the old name's
+  timelines = addTimelineYear(timelines, "ABC", "Abc Holdings", "2013", 1)
+  timelines = addTimelineYear(timelines, "XYZ", "Xyz Corp", "2013", 3)
+  sortTimelines(timelines)
+  if len(timelines) != 2 || timelines[0].Ticker != "ABC" || timelines[0].FirstYear != "2013" ||
+     timelines[0].LastYear != "2019" || timelines[0].Filings != 4 || timelines[0].Years["2019"] != 2 {
+    t.Fatalf("timelines %v, expected ABC from 2013 to 2019 first.", timelines)
+  }
+  if timelines[1].Ticker != "XYZ" || timelines[1].FirstYear != "2013" || timelines[1].Filings != 3 {
+    t.Fatalf("timelines %v, expected XYZ in 2013 after ABC.", timelines)
+  }
+}
+
+func TestTimeline(t *testing.T) {
+  searcher = &fakeSearcher{total: 3}
+  last := strconv.Itoa(yearUpperBound)
+
+  w := httptest.NewRecorder()
+  processParameters(timelineHandler)(w, httptest.NewRequest(http.MethodGet,
+    "/timeline?searchterm=cloud", nil))
+  body := w.Body.String()
+  if w.Code != http.StatusOK || !strings.Contains(body, "When companies mentioned cloud") {
+    t.Fatalf("status code %d, expected the timeline page.", w.Code)
+  }
+  // XYZ first mentioned it in 2012, then ABC
+  xyz, abc := strings.Index(body, `tickers=XYZ&amp;to=` + last), strings.Index(body, ">ABC</a>")
+  if xyz < 0 || abc < xyz {
+    t.Fatalf("body %s, expected XYZ linked to its hits before ABC.", body)
+  }
+  if strings.Count(body, `class="year mentioned"`) != 3 {
+    t.Fatalf("body %s, expected three years with mentions.", body)
+  }
+
+  w = httptest.NewRecorder()
+  processAPIParameters(apiTimelineHandler)(w, httptest.NewRequest(http.MethodGet,
+    "/api/v1/timeline?searchterm=cloud&section=All+sections", nil))
+  var timeline APITimeline
+  if err := json.NewDecoder(w.Body).Decode(&timeline); err != nil {
+    t.Fatalf("decoding timeline: %s.", err)
+  }
+  if timeline.Section != allSections || len(timeline.Companies) != 2 ||
+     timeline.Companies[0].Ticker != "XYZ" || timeline.Companies[0].FirstYear != "2012" ||
+     timeline.Companies[0].Years[last] != 2 || timeline.Companies[1].Filings != 3 {
+    t.Fatalf("timeline %v, expected XYZ then ABC.", timeline)
+  }
+}